@@ -0,0 +1,87 @@
+package jwk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCache is the persisted form of a fetched JWKS.
+type diskCache struct {
+	FetchedAt    time.Time       `json:"fetched_at"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Source       string          `json:"source"`
+	JWKS         json.RawMessage `json:"jwks"`
+}
+
+// saveCache writes the last fetched JWKS to CachePath,
+// replacing it atomically.
+func (client *Client) saveCache(fetchedAt time.Time) error {
+	data, err := json.Marshal(diskCache{
+		FetchedAt:    fetchedAt,
+		ETag:         client.last.ETag,
+		LastModified: client.last.LastModified,
+		Source:       client.source.String(),
+		JWKS:         client.last.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := client.config.CachePath
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCache loads JWKS from CachePath if it was fetched from
+// the same source within CacheMaxAge.
+func (client *Client) loadCache() error {
+	data, err := ioutil.ReadFile(client.config.CachePath)
+	if err != nil {
+		return err
+	}
+
+	var cache diskCache
+	if err = json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("Invalid cache %s: %w", client.config.CachePath, err)
+	}
+	if cache.Source != client.source.String() {
+		return fmt.Errorf("Cache %s is from '%s'", client.config.CachePath, cache.Source)
+	}
+	age := time.Since(cache.FetchedAt)
+	if maxAge := client.config.CacheMaxAge; maxAge > 0 && age > maxAge {
+		return fmt.Errorf("Cache %s expired %s ago", client.config.CachePath, (age - maxAge).Round(time.Second))
+	}
+
+	keySet, err := client.decodeKeySet(cache.JWKS)
+	if err != nil {
+		return err
+	}
+
+	client.fetchMutex.Lock()
+	client.last = &FetchResult{Body: cache.JWKS, ETag: cache.ETag, LastModified: cache.LastModified}
+	client.fetchMutex.Unlock()
+
+	client.fetchedAt.Store(cache.FetchedAt.UnixNano())
+	old, current := client.storeKeySet(keySet)
+	client.notifyRefresh(old, current)
+	return nil
+}
@@ -0,0 +1,125 @@
+package jwk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+	config := func(config *ClientConfig) error {
+		config.CachePath = cachePath
+		config.RetryPolicy = RetryPolicy{}
+		return nil
+	}
+
+	transport := &mockConditionalTransport{mockBodyTransport: mockBodyTransport{body}, etag: `"v1"`}
+	jwkClient, _ := NewClient("http://andy2046.io", config)
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	jwkClient.Stop()
+
+	data, err := ioutil.ReadFile(cachePath)
+	assert(t, err == nil, fmt.Sprintf("it should save cache %s", err))
+	var cache diskCache
+	err = json.Unmarshal(data, &cache)
+	assert(t, err == nil, fmt.Sprintf("fail to parse cache %s", err))
+	assert(t, cache.ETag == `"v1"` && cache.Source == "http://andy2046.io", "it should save cache metadata")
+	assert(t, string(cache.JWKS) == body, "it should save fetched JWKS")
+	matches, _ := filepath.Glob(cachePath + ".tmp*")
+	assert(t, len(matches) == 0, "it should not leave temporary files")
+
+	// endpoint unreachable, keys loaded from cache
+	jwkClient, _ = NewClient("http://andy2046.io", config)
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockStatusTransport{http.StatusServiceUnavailable}}
+	var refreshed int
+	jwkClient.Subscribe(Listener{
+		OnRefresh: func(old, current *JSONWebKeySet, diff *KeySetDiff) {
+			refreshed++
+		},
+	})
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("Start should load cache %s", err))
+	defer jwkClient.Stop()
+	_, err = jwkClient.Key("ABCDEFG")
+	assert(t, err == nil, fmt.Sprintf("fail to get cached Key %s", err))
+	status := jwkClient.Status()
+	assert(t, status.LastSuccess.Equal(cache.FetchedAt), "it should report cache fetch time as last success")
+	assert(t, status.ConsecutiveFailures == 1, "it should report the failed fetch")
+	assert(t, refreshed == 1, "it should notify keys loaded from cache")
+
+	// conditional request uses cached validators
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	jwkClient.ForceRefresh()
+	req := transport.requests[len(transport.requests)-1]
+	assert(t, req.Header.Get("If-None-Match") == `"v1"`, "it should send cached ETag")
+	assert(t, jwkClient.Status().ConsecutiveFailures == 0, "Not Modified should be a success")
+
+	// initial fetch timed out, keys loaded from cache and refresh scheduled
+	jwkClient, _ = NewClient("http://andy2046.io", config)
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockContextTransport{mockBodyTransport{body}, make(chan struct{})}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = jwkClient.StartContext(ctx)
+	assert(t, err == nil, fmt.Sprintf("StartContext should load cache %s", err))
+	defer jwkClient.Stop()
+	assert(t, !jwkClient.NextRefresh().IsZero(), "it should schedule refresh after loading cache")
+}
+
+func TestDiskCacheRejected(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+	writeCache := func(cache diskCache) {
+		data, _ := json.Marshal(cache)
+		if err := ioutil.WriteFile(cachePath, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unavailable := &mockStatusTransport{http.StatusServiceUnavailable}
+	start := func(transport http.RoundTripper) error {
+		jwkClient, _ := NewClient("http://andy2046.io", func(config *ClientConfig) error {
+			config.CachePath = cachePath
+			config.CacheMaxAge = time.Hour
+			config.RetryPolicy = RetryPolicy{}
+			return nil
+		})
+		jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+		defer jwkClient.Stop()
+		return jwkClient.Start()
+	}
+	jwks := json.RawMessage(`{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`)
+
+	var httpErr *HTTPError
+	err := start(unavailable)
+	assert(t, errors.As(err, &httpErr), fmt.Sprintf("Start should fail without cache, not %s", err))
+
+	writeCache(diskCache{FetchedAt: time.Now().Add(-2 * time.Hour), Source: "http://andy2046.io", JWKS: jwks})
+	err = start(unavailable)
+	assert(t, errors.As(err, &httpErr), fmt.Sprintf("Start should not load expired cache, not %s", err))
+
+	writeCache(diskCache{FetchedAt: time.Now(), Source: "http://example.com", JWKS: jwks})
+	err = start(unavailable)
+	assert(t, errors.As(err, &httpErr), fmt.Sprintf("Start should not load cache of another endpoint, not %s", err))
+
+	writeCache(diskCache{FetchedAt: time.Now(), Source: "http://andy2046.io", JWKS: json.RawMessage(`{"keys":[{"kty":"RSA","n":"VKOoRQ","e":"AQAB"}]}`)})
+	err = start(unavailable)
+	assert(t, errors.As(err, &httpErr), fmt.Sprintf("Start should not load cached weak key, not %s", err))
+
+	writeCache(diskCache{FetchedAt: time.Now(), Source: "http://andy2046.io", JWKS: jwks})
+	err = start(&mockStatusTransport{http.StatusNotFound})
+	assert(t, errors.As(err, &httpErr), fmt.Sprintf("Start should not load cache on permanent error, not %s", err))
+
+	err = start(&mockBodyTransport{`{"keys":[{"kty":"RSA","n":"VKOoRQ","e":"AQAB"}]}`})
+	assert(t, errors.Is(err, ErrWeakKey), fmt.Sprintf("Start should not load cache on rejected key set, not %s", err))
+
+	err = start(unavailable)
+	assert(t, err == nil, fmt.Sprintf("Start should load cache %s", err))
+}
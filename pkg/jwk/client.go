@@ -1,23 +1,32 @@
 package jwk
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	defaultRequestTimeout = 30 * time.Second
 	defaultCacheTimeout   = 600 * time.Second
+	defaultMinRefresh     = 60 * time.Second
+	defaultMaxRefresh     = 24 * time.Hour
+	defaultUnknownRefresh = 30 * time.Second
+	defaultUnknownCache   = 5 * time.Minute
+	defaultCacheMaxAge    = 24 * time.Hour
 	methodGET             = "GET"
 )
 
@@ -33,20 +42,71 @@ type (
 		CacheTimeout     time.Duration
 		RequestTimeout   time.Duration
 		Headers          map[string]string
+		// LenientDecoding skips unsupported or malformed keys
+		// instead of failing the whole key set.
+		LenientDecoding bool
+		// OnSkippedKey is called for each key skipped by LenientDecoding,
+		// KeyPolicy or VerifyCertificates.
+		OnSkippedKey func(KeyWarning)
+		// VerifyCertificates skips keys whose x5c chain does not verify,
+		// against the CA Cert in CertificateCACertPath, or CACertPath
+		// if unset, or the system pool if both are unset.
+		VerifyCertificates    bool
+		CertificateCACertPath string
+		// KeyPolicy is the minimum strength of fetched keys, weaker keys
+		// are skipped, the key set is rejected if none is left.
+		KeyPolicy KeyPolicy
+		// MinRefreshInterval and MaxRefreshInterval clamp the refresh interval
+		// from the Cache-Control or Expires header of the JWKS response,
+		// CacheTimeout is used if the response has neither. Expired and
+		// no-cache responses are refreshed after MinRefreshInterval.
+		MinRefreshInterval time.Duration
+		MaxRefreshInterval time.Duration
+		// UnknownKeyRefreshInterval is the minimum interval between
+		// refreshes triggered by GetKey on unknown key IDs, and
+		// UnknownKeyCacheTimeout how long such key IDs are not retried.
+		UnknownKeyRefreshInterval time.Duration
+		UnknownKeyCacheTimeout    time.Duration
+		// RetryPolicy configures retries of failed fetches.
+		RetryPolicy RetryPolicy
+		// MaxStaleness is the maximum age of cached keys since the last
+		// successful fetch, after which lookups fail with ErrStaleKeySet,
+		// zero for no limit.
+		MaxStaleness time.Duration
+		// CachePath is a file where fetched JWKS are saved, and loaded
+		// from by Start if the endpoint is unreachable, on errors
+		// RetryPolicy retries, unless older than CacheMaxAge,
+		// zero for no limit.
+		CachePath   string
+		CacheMaxAge time.Duration
+		// MirrorURLs serve the same JWKS as the endpoint,
+		// see HTTPSource Mirrors.
+		MirrorURLs []string
 	}
 
 	// Client fetch keys from a JSON Web Key set endpoint.
 	Client struct {
 		config      *ClientConfig
-		httpClient  *http.Client
-		endpointURL string
-		keySet      *JSONWebKeySet
+		certRoots   *x509.CertPool
+		source      Source
+		snapshot    atomic.Value // *keySnapshot
+		fetchMutex  sync.Mutex
 		mutex       sync.RWMutex
 		doneChan    chan struct{}
 		refreshChan chan struct{}
+		exitChan    chan struct{}
+		ctx         context.Context // cancelled on Stop
+		cancel      context.CancelFunc
 		dog         *watchdog
 		closed      bool
 		started     bool
+		watching    bool
+		listeners   map[int]Listener
+		listenerID  int
+		unknownKeys unknownKeys
+		fetchStatus fetchStatus
+		fetchedAt   atomic.Int64 // UnixNano of the last successful fetch
+		last        *FetchResult // last fetched JWKS, guarded by fetchMutex
 	}
 
 	// Option applies config to Client Config.
@@ -56,13 +116,50 @@ type (
 var (
 	// DefaultClientConfig is the default Client Config.
 	DefaultClientConfig = ClientConfig{
-		CacheTimeout:   defaultCacheTimeout,
-		RequestTimeout: defaultRequestTimeout,
+		CacheTimeout:              defaultCacheTimeout,
+		RequestTimeout:            defaultRequestTimeout,
+		MinRefreshInterval:        defaultMinRefresh,
+		MaxRefreshInterval:        defaultMaxRefresh,
+		KeyPolicy:                 DefaultKeyPolicy,
+		UnknownKeyRefreshInterval: defaultUnknownRefresh,
+		UnknownKeyCacheTimeout:    defaultUnknownCache,
+		RetryPolicy:               DefaultRetryPolicy,
+		CacheMaxAge:               defaultCacheMaxAge,
 	}
 )
 
 // NewClient returns a new JWKS client.
 func NewClient(jwksEndpoint string, options ...Option) (*Client, error) {
+	client, tlsConfig, err := newClient(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	client.source = &HTTPSource{
+		URL:     jwksEndpoint,
+		Mirrors: client.config.MirrorURLs,
+		Headers: client.config.Headers,
+		Client: &http.Client{
+			Timeout:   client.config.RequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+	return client, nil
+}
+
+// NewClientWithSource returns a new client loading JWKS from source,
+// HTTP options such as Headers and RequestTimeout do not apply.
+func NewClientWithSource(source Source, options ...Option) (*Client, error) {
+	client, _, err := newClient(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	client.source = source
+	return client, nil
+}
+
+func newClient(options ...Option) (*Client, *tls.Config, error) {
 	config := DefaultClientConfig
 	setOption(&config, options...)
 	if config.logger == nil {
@@ -76,7 +173,7 @@ func NewClient(jwksEndpoint string, options ...Option) (*Client, error) {
 		CAs, err := loadCACert(config.AppendCACert, config.CACertPath)
 		if err != nil {
 			config.logger.Printf("Error from NewClient: %s", err)
-			return nil, err
+			return nil, nil, err
 		}
 		tlsConfig.RootCAs = CAs
 	}
@@ -84,23 +181,44 @@ func NewClient(jwksEndpoint string, options ...Option) (*Client, error) {
 		tlsConfig.ServerName = config.ServerHostName
 	}
 
+	var certRoots *x509.CertPool
+	if config.VerifyCertificates {
+		var err error
+		switch {
+		case config.CertificateCACertPath != "":
+			certRoots, err = loadCACert(config.AppendCACert, config.CertificateCACertPath)
+		case tlsConfig.RootCAs != nil:
+			certRoots = tlsConfig.RootCAs
+		default:
+			certRoots, err = x509.SystemCertPool()
+		}
+		if err != nil {
+			config.logger.Printf("Error from NewClient: %s", err)
+			return nil, nil, err
+		}
+	}
+
 	client := &Client{
 		config:      &config,
-		endpointURL: jwksEndpoint,
-		keySet:      &JSONWebKeySet{},
+		certRoots:   certRoots,
 		doneChan:    make(chan struct{}),
 		refreshChan: make(chan struct{}),
+		exitChan:    make(chan struct{}),
 		dog:         createWatchdog(config.CacheTimeout),
-		httpClient: &http.Client{
-			Timeout:   config.RequestTimeout,
-			Transport: &http.Transport{TLSClientConfig: tlsConfig},
-		},
 	}
-	return client, nil
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.snapshot.Store(newKeySnapshot(&JSONWebKeySet{}))
+	return client, tlsConfig, nil
 }
 
 // Start to fetch and cache JWKS.
 func (client *Client) Start() error {
+	return client.StartContext(context.Background())
+}
+
+// StartContext to fetch and cache JWKS,
+// ctx bounds the initial fetch only.
+func (client *Client) StartContext(ctx context.Context) error {
 	started := client.isStarted()
 	if started {
 		client.config.logger.Println("Warning from Start: Client already started")
@@ -110,30 +228,69 @@ func (client *Client) Start() error {
 	client.started = true
 	client.mutex.Unlock()
 
-	if err := client.fetchJWKS(); err != nil {
-		return err
+	if err := client.initialFetch(ctx); err != nil {
+		// only fall back to the cache if the endpoint is unreachable
+		if _, ok := retriable(err); !ok || client.config.CachePath == "" || client.isClosed() {
+			return err
+		}
+		if cacheErr := client.loadCache(); cacheErr != nil {
+			client.config.logger.Printf("Warning from Start: fail to load cache %s\n", cacheErr)
+			return err
+		}
+		client.config.logger.Printf("Warning from Start: %s, keys loaded from cache\n", err)
+		// a fetch cancelled by ctx does not schedule the next one
+		if client.NextRefresh().IsZero() {
+			next := client.dog.period
+			if d, ok := client.config.RetryPolicy.delay(1, err); ok {
+				next = d
+			}
+			client.dog.reset(next)
+		}
 	}
 
-	closed := client.isClosed()
-	if closed {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.closed {
 		client.config.logger.Println("Warning from Start: Client closed")
 		return fmt.Errorf("Client closed")
 	}
 
+	client.watching = true
 	go client.watch()
 	return nil
 }
 
+// initialFetch fetches JWKS, retrying on failure per RetryPolicy.
+func (client *Client) initialFetch(ctx context.Context) error {
+	retry, err := client.fetchJWKS(ctx, true)
+	for err != nil && retry > 0 {
+		client.config.logger.Printf("Warning from Start: %s, retry in %s\n", err, retry)
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-client.ctx.Done():
+			timer.Stop()
+			return err
+		}
+		retry, err = client.fetchJWKS(ctx, true)
+	}
+	return err
+}
+
 func (client *Client) watch() {
+	defer close(client.exitChan)
 	fetch := func() {
-		if err := client.fetchJWKS(); err != nil {
+		if _, err := client.fetchJWKS(client.ctx, true); err != nil {
 			client.config.logger.Printf("Error from fetchJWKS: %s\n", err)
 		}
 	}
 
 	for {
 		select {
-		case <-client.dog.ticker.C:
+		case <-client.dog.timer.C:
 			fetch()
 		case _, open := <-client.doneChan:
 			if !open {
@@ -145,10 +302,9 @@ func (client *Client) watch() {
 				return
 			}
 			if client.config.EnableDebug {
-				client.config.logger.Println("force cache refresh and resetTicker")
+				client.config.logger.Println("force cache refresh")
 			}
 			fetch()
-			client.dog.resetTicker()
 			client.refreshChan <- struct{}{}
 		}
 	}
@@ -172,31 +328,126 @@ func (client *Client) ForceRefresh() {
 	<-client.refreshChan
 }
 
+// Refresh fetches and caches JWKS, and on success reschedules the next refresh.
+func (client *Client) Refresh(ctx context.Context) error {
+	started := client.isStarted()
+	if !started {
+		return fmt.Errorf("Client not started")
+	}
+
+	closed := client.isClosed()
+	if closed {
+		return fmt.Errorf("Client closed")
+	}
+	_, err := client.fetchJWKS(ctx, false)
+	return err
+}
+
 // Stop to update cache periodically.
 func (client *Client) Stop() {
+	if !client.stop() {
+		client.config.logger.Println("Warning from Stop: Client closed")
+	}
+}
+
+// Close stops to update cache, cancels in-flight fetches
+// and waits for the periodic update to exit or ctx to be done.
+func (client *Client) Close(ctx context.Context) error {
+	if !client.stop() {
+		return fmt.Errorf("Client closed")
+	}
+
+	client.mutex.RLock()
+	watching := client.watching
+	client.mutex.RUnlock()
+	if !watching {
+		return nil
+	}
+
+	select {
+	case <-client.exitChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop closes client, it returns false if already closed.
+func (client *Client) stop() bool {
 	client.mutex.Lock()
 	defer client.mutex.Unlock()
 
 	if client.closed {
-		client.config.logger.Println("Warning from Stop: Client closed")
-		return
+		return false
 	}
 	client.closed = true
+	client.cancel()
 	close(client.doneChan)
+	return true
 }
 
-// KeySet returns the cached JSONWebKeySet.
+// NextRefresh returns the time of the next scheduled refresh,
+// or the zero time if none is scheduled.
+func (client *Client) NextRefresh() time.Time {
+	return client.dog.nextTick()
+}
+
+// KeySet returns a deep copy of the cached JSONWebKeySet, key material
+// included, changes to it do not affect the client. Certificates are
+// shared and must not be modified. As it copies every key, use Key or
+// GetKey to look up keys per request.
 func (client *Client) KeySet() *JSONWebKeySet {
-	client.mutex.RLock()
-	defer client.mutex.RUnlock()
+	return client.loadSnapshot().keySet()
+}
 
-	return client.keySet
+// FreshKeySet returns KeySet, or ErrStaleKeySet
+// if keys are older than MaxStaleness.
+func (client *Client) FreshKeySet() (*JSONWebKeySet, error) {
+	if err := client.checkStale(); err != nil {
+		return nil, err
+	}
+	return client.KeySet(), nil
+}
+
+// Key returns copies of cached keys by key ID, or ErrKeyNotFound,
+// found through an index, only matching keys are copied and
+// certificates are shared, see KeySet.
+// It returns ErrStaleKeySet if keys are older than MaxStaleness.
+func (client *Client) Key(kid string) ([]JSONWebKey, error) {
+	if err := client.checkStale(); err != nil {
+		return nil, err
+	}
+	keys := client.loadSnapshot().key(kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+	}
+	return keys, nil
 }
 
 // PreLoad `kid` and `rsa.PublicKey` pair into client.
 func (client *Client) PreLoad(kid string, key *rsa.PublicKey) {
 	jwkey := JSONWebKey{Key: key, KeyID: kid, Algorithm: "RS256", Use: "sig"}
-	client.keySet.Keys = append(client.keySet.Keys, jwkey)
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	keySet := client.loadSnapshot().set
+	keySet.Keys = append(keySet.Keys[:len(keySet.Keys):len(keySet.Keys)], jwkey)
+	client.snapshot.Store(newKeySnapshot(&keySet))
+}
+
+func (client *Client) loadSnapshot() *keySnapshot {
+	return client.snapshot.Load().(*keySnapshot)
+}
+
+// storeKeySet swaps the cached key set and returns the previous snapshot.
+func (client *Client) storeKeySet(keySet *JSONWebKeySet) (old, current *keySnapshot) {
+	current = newKeySnapshot(keySet)
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	old = client.loadSnapshot()
+	client.snapshot.Store(current)
+	return
 }
 
 func (client *Client) isClosed() bool {
@@ -211,37 +462,143 @@ func (client *Client) isStarted() bool {
 	return client.started
 }
 
-func (client *Client) fetchJWKS() (err error) {
-	client.mutex.Lock()
-	defer client.mutex.Unlock()
+// fetchJWKS fetches and caches JWKS and schedules the next refresh,
+// on failure it returns the retry delay, or 0 if not to retry.
+// Failed on-demand fetches, not scheduled, keep the pending schedule.
+func (client *Client) fetchJWKS(ctx context.Context, scheduled bool) (retry time.Duration, err error) {
+	retry, notify, err := client.fetchLocked(ctx, scheduled)
+	// listeners run after fetchMutex is released,
+	// so they may call Refresh or GetKey
+	notify()
+	return retry, err
+}
+
+// fetchLocked does the fetchJWKS work under fetchMutex,
+// it returns the listener notification to be sent.
+func (client *Client) fetchLocked(ctx context.Context, scheduled bool) (retry time.Duration, notify func(), err error) {
+	client.fetchMutex.Lock()
+	defer client.fetchMutex.Unlock()
+
+	// fetches are also cancelled on Stop
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-client.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	keySet, result, err := client.fetch(ctx)
+	if err != nil {
+		failures := client.recordFailure(err)
+		if ctx.Err() != nil {
+			// cancelled, keep the pending schedule
+			return 0, func() { client.notifyError(err) }, err
+		}
+		next := client.dog.period
+		if d, ok := client.config.RetryPolicy.delay(failures, err); ok {
+			retry, next = d, d
+		}
+		if scheduled {
+			client.dog.reset(next)
+		}
+		return retry, func() { client.notifyError(err) }, err
+	}
+
+	fetchedAt := time.Now()
+	client.recordSuccess(fetchedAt)
+	if client.config.CachePath != "" {
+		if err := client.saveCache(fetchedAt); err != nil {
+			client.config.logger.Printf("Warning from fetchJWKS: fail to save cache %s\n", err)
+		}
+	}
+	client.dog.reset(client.refreshInterval(result.Expires, time.Now()))
+	if keySet == nil {
+		// not modified, keep the cached key set
+		current := client.loadSnapshot()
+		return 0, func() { client.notifyRefresh(current, current) }, nil
+	}
+	old, current := client.storeKeySet(keySet)
+	return 0, func() { client.notifyRefresh(old, current) }, nil
+}
+
+// refreshInterval returns the refresh interval until expires,
+// clamped to MinRefreshInterval and MaxRefreshInterval,
+// CacheTimeout if expires is zero.
+func (client *Client) refreshInterval(expires time.Time, now time.Time) time.Duration {
+	if expires.IsZero() {
+		return client.dog.period
+	}
+	d := expires.Sub(now)
+	if d < client.config.MinRefreshInterval {
+		d = client.config.MinRefreshInterval
+	}
+	if max := client.config.MaxRefreshInterval; max > 0 && d > max {
+		d = max
+	}
+	if d < 0 {
+		// already expired, refresh at the minimum interval
+		return 0
+	}
+	return d
+}
 
+// fetch returns the fetched key set, or a nil key set if
+// the source replies the cached one is not modified.
+func (client *Client) fetch(ctx context.Context) (keySet *JSONWebKeySet, result *FetchResult, err error) {
 	if client.config.EnableDebug {
-		client.config.logger.Printf("fetchJWKS from %s (period %s)\n", client.endpointURL, client.dog.period)
+		client.config.logger.Printf("fetchJWKS from %s (period %s)\n", client.source, client.dog.period)
 	}
 
-	var req *http.Request
-	req, err = http.NewRequest(methodGET, client.endpointURL, nil)
-	if err != nil {
+	if result, err = client.source.Fetch(ctx, client.last); err != nil {
 		return
 	}
-	if len(client.config.Headers) != 0 {
-		for k, v := range client.config.Headers {
-			req.Header.Add(k, v)
+	if result.NotModified {
+		if client.last == nil {
+			return nil, nil, fmt.Errorf("Source %s replied not modified on first fetch", client.source)
 		}
+		return nil, result, nil
 	}
-	var resp *http.Response
-	if resp, err = client.httpClient.Do(req); err != nil {
+
+	if keySet, err = client.decodeKeySet(result.Body); err != nil {
 		return
-	} else if resp.StatusCode >= 400 {
-		return fmt.Errorf("fetchJWKS request returned non-success StatusCode %d", resp.StatusCode)
 	}
-	defer closeBody(resp)
+	client.last = result
+	return keySet, result, nil
+}
 
-	keySet := &JSONWebKeySet{}
-	if err = json.NewDecoder(resp.Body).Decode(keySet); err == nil {
-		client.keySet = keySet
+func (client *Client) decodeKeySet(data []byte) (*JSONWebKeySet, error) {
+	keySet, warnings, err := parseKeySet(data, client.config.LenientDecoding, client.checkKey)
+	if err != nil {
+		return nil, err
 	}
-	return
+	for _, w := range warnings {
+		client.config.logger.Printf("Warning from fetchJWKS: %s\n", w)
+		if client.config.OnSkippedKey != nil {
+			client.config.OnSkippedKey(w)
+		}
+	}
+	return keySet, nil
+}
+
+// checkKey checks a fetched key against the client config.
+func (client *Client) checkKey(key *JSONWebKey) error {
+	if err := key.Validate(client.config.KeyPolicy); err != nil {
+		return err
+	}
+
+	if client.config.VerifyCertificates {
+		_, err := key.VerifyCertificates(x509.VerifyOptions{
+			Roots:     client.certRoots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("Fail to verify x5c field: %w", err)
+		}
+	}
+	return nil
 }
 
 func setOption(c *ClientConfig, options ...func(*ClientConfig) error) error {
@@ -261,6 +618,57 @@ func closeBody(resp *http.Response) {
 	}
 }
 
+// cacheLifetime returns how long a response can be cached according to
+// its Cache-Control and Expires headers, ok is false if neither is set.
+func cacheLifetime(header http.Header, now time.Time) (d time.Duration, ok bool) {
+	for _, directive := range strings.Split(strings.Join(header.Values("Cache-Control"), ","), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0, true
+		case "max-age":
+			seconds, err := parseDeltaSeconds(strings.Trim(value, `"`))
+			if err != nil {
+				continue
+			}
+			d, ok = seconds, true
+		}
+	}
+
+	if ok {
+		if age, err := parseDeltaSeconds(header.Get("Age")); err == nil {
+			d -= age
+		}
+		return
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		// an invalid Expires means already expired
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, true
+		}
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			now = date
+		}
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// parseDeltaSeconds parses a delta-seconds value as in RFC 7234,
+// values too large are capped at 2^31 seconds.
+func parseDeltaSeconds(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseUint(value, 10, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, err
+	}
+	if err != nil || seconds > 1<<31 {
+		seconds = 1 << 31
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
 func loadCACert(appendCACert bool, CACertPath string) (*x509.CertPool, error) {
 	CAs := x509.NewCertPool()
 	if appendCACert {
@@ -1,11 +1,22 @@
 package jwk
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestConfigDefaults(t *testing.T) {
@@ -14,6 +25,13 @@ func TestConfigDefaults(t *testing.T) {
 	assert(t, config.RequestTimeout == defaultRequestTimeout, "RequestTimeout config")
 	assert(t, config.DisableStrictTLS == false, "DisableStrictTLS config")
 	assert(t, config.EnableDebug == false, "EnableDebug config")
+	assert(t, config.KeyPolicy.MinRSABits == 2048, "KeyPolicy config")
+	assert(t, config.MinRefreshInterval == defaultMinRefresh, "MinRefreshInterval config")
+	assert(t, config.MaxRefreshInterval == defaultMaxRefresh, "MaxRefreshInterval config")
+	assert(t, config.UnknownKeyRefreshInterval == defaultUnknownRefresh, "UnknownKeyRefreshInterval config")
+	assert(t, config.UnknownKeyCacheTimeout == defaultUnknownCache, "UnknownKeyCacheTimeout config")
+	assert(t, config.RetryPolicy == DefaultRetryPolicy, "RetryPolicy config")
+	assert(t, config.CacheMaxAge == defaultCacheMaxAge, "CacheMaxAge config")
 }
 
 var testCertificatesStr = "MIIC+DCCAeCgAwIBAgIJBIGjYW6hFpn2MA0GCSqGSIb3DQEBBQUAMCMxITAfBgNVBAMTGGN1c3RvbWVyLWRlbW9zLmF1dGgwLmNvbTAeFw0xNjExMjIyMjIyMDVaFw0zMDA4MDEyMjIyMDVaMCMxITAfBgNVBAMTGGN1c3RvbWVyLWRlbW9zLmF1dGgwLmNvbTCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoCggEBAMnjZc5bm/eGIHq09N9HKHahM7Y31P0ul+A2wwP4lSpIwFrWHzxw88/7Dwk9QMc+orGXX95R6av4GF+Es/nG3uK45ooMVMa/hYCh0Mtx3gnSuoTavQEkLzCvSwTqVwzZ+5noukWVqJuMKNwjL77GNcPLY7Xy2/skMCT5bR8UoWaufooQvYq6SyPcRAU4BtdquZRiBT4U5f+4pwNTxSvey7ki50yc1tG49Per/0zA4O6Tlpv8x7Red6m1bCNHt7+Z5nSl3RX/QYyAEUX1a28VcYmR41Osy+o2OUCXYdUAphDaHo4/8rbKTJhlu8jEcc1KoMXAKjgaVZtG/v5ltx6AXY0CAwEAAaMvMC0wDAYDVR0TBAUwAwEB/zAdBgNVHQ4EFgQUQxFG602h1cG+pnyvJoy9pGJJoCswDQYJKoZIhvcNAQEFBQADggEBAGvtCbzGNBUJPLICth3mLsX0Z4z8T8iu4tyoiuAshP/Ry/ZBnFnXmhD8vwgMZ2lTgUWwlrvlgN+fAtYKnwFO2G3BOCFw96Nm8So9sjTda9CCZ3dhoH57F/hVMBB0K6xhklAc0b5ZxUpCIN92v/w+xZoz1XQBHe8ZbRHaP1HpRM4M7DJk2G5cgUCyu3UBvYS41sHvzrxQ3z7vIePRA4WF4bEkfX12gvny0RsPkrbVMXX1Rj9t6V7QXrbPYBAO+43JvDGYawxYVvLhz+BJ45x50GFQmHszfY3BR9TPK8xmMmQwtIvLu1PMttNCs7niCYkSiUv2sc2mlq1i3IashGkkgmo="
@@ -27,8 +45,8 @@ func (t *mockSuccessTransport) RoundTrip(req *http.Request) (*http.Response, err
 		StatusCode: http.StatusOK,
 	}
 	responseBody := fmt.Sprintf(
-		`{"keys":[{"alg":"RS256","kty":"RSA","use":"sig","x5c":["%s"],"n":"VKOoRQ","e":"AQAB","kid":"ABCDEFG"}]}`,
-		testCertificatesStr)
+		`{"keys":[{"alg":"RS256","kty":"RSA","use":"sig","x5c":["%s"],"n":"%s","e":"AQAB","kid":"ABCDEFG"}]}`,
+		testCertificatesStr, testCertificateModulus)
 	response.Body = ioutil.NopCloser(strings.NewReader(responseBody))
 	return response, nil
 }
@@ -43,7 +61,7 @@ func TestSuccessHttpRequest(t *testing.T) {
 	})
 	httpClient := http.DefaultClient
 	httpClient.Transport = &mockSuccessTransport{}
-	jwkClient.httpClient = httpClient
+	jwkClient.source.(*HTTPSource).Client = httpClient
 
 	err := jwkClient.Start()
 	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
@@ -57,3 +75,649 @@ func TestSuccessHttpRequest(t *testing.T) {
 	assert(t, jwkClient.closed == true, "jwkClient should be closed")
 	jwkClient.ForceRefresh()
 }
+
+type mockBodyTransport struct {
+	body string
+}
+
+func (t *mockBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Header:     make(http.Header),
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(t.body)),
+	}, nil
+}
+
+func TestLenientDecoding(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"},` +
+		`{"kty":"Ed448","kid":"GFEDCBA"}]}`
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockBodyTransport{body}}
+	err := jwkClient.Start()
+	assert(t, err != nil, "strict client should fail to Start")
+	jwkClient.Stop()
+
+	var skipped []KeyWarning
+	jwkClient, _ = NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.LenientDecoding = true
+		config.OnSkippedKey = func(w KeyWarning) {
+			skipped = append(skipped, w)
+		}
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockBodyTransport{body}}
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	keySet := jwkClient.KeySet()
+	assert(t, len(keySet.Keys) == 1, fmt.Sprintf("it should return key set with one key not %d", len(keySet.Keys)))
+	assert(t, len(skipped) == 1 && skipped[0].KeyID == "GFEDCBA", "it should report the skipped key")
+}
+
+func TestVerifyCertificatesConfig(t *testing.T) {
+	caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fromBase64Bytes(testCertificatesStr)})
+	if err := ioutil.WriteFile(caCertPath, caCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"keys":[`+
+		`{"kty":"RSA","kid":"ABCDEFG","n":"%s","e":"AQAB","x5c":["%s"]},`+
+		`{"kty":"RSA","kid":"GFEDCBA","n":"VKOoRQ","e":"AQAB","x5c":["%s"]}]}`,
+		testCertificateModulus, testCertificatesStr, testCertificatesStr)
+
+	jwkClient, err := NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.VerifyCertificates = true
+		config.CertificateCACertPath = caCertPath
+		config.LenientDecoding = true
+		return nil
+	})
+	assert(t, err == nil, fmt.Sprintf("fail to NewClient %s", err))
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockBodyTransport{body}}
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	keySet := jwkClient.KeySet()
+	assert(t, len(keySet.Keys) == 1, fmt.Sprintf("it should return key set with one key not %d", len(keySet.Keys)))
+	assert(t, keySet.Keys[0].KeyID == "ABCDEFG", "it should keep the key matching its certificate")
+	keys, err := jwkClient.Key("ABCDEFG")
+	assert(t, err == nil && keys[0].Certificates[0] == keySet.Keys[0].Certificates[0], "lookups should share parsed certificates")
+
+	_, err = NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.VerifyCertificates = true
+		config.CertificateCACertPath = filepath.Join(os.TempDir(), "jwks-missing-ca.pem")
+		return nil
+	})
+	assert(t, err != nil, "NewClient should fail on missing CA Cert")
+}
+
+type mockBlockingTransport struct {
+	mockBodyTransport
+	release chan struct{}
+}
+
+func (t *mockBlockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-t.release
+	return t.mockBodyTransport.RoundTrip(req)
+}
+
+func TestKeySetSnapshot(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB","key_ops":["verify"]}]}`
+	transport := &mockBlockingTransport{mockBodyTransport{body}, make(chan struct{}, 1)}
+	transport.release <- struct{}{}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	keySet := jwkClient.KeySet()
+	keySet.Keys[0].KeyID = "mutated"
+	keySet.Keys[0].KeyOps[0] = "mutated"
+	keySet.Keys[0].Key.(*rsa.PublicKey).E = 3
+	keySet.Keys[0].Key.(*rsa.PublicKey).N.SetInt64(1)
+	keySet.Keys = append(keySet.Keys, JSONWebKey{KeyID: "appended"})
+
+	keys, err := jwkClient.Key("ABCDEFG")
+	assert(t, err == nil && len(keys) == 1, fmt.Sprintf("it should return one key %s", err))
+	assert(t, keys[0].KeyOps[0] == "verify", "cached key should not be mutated")
+	assert(t, keys[0].Key.(*rsa.PublicKey).E == 65537, "cached key material should not be mutated")
+	assert(t, keys[0].Key.(*rsa.PublicKey).N.BitLen() == 2048, "cached key material should not be mutated")
+	keys[0].Key.(*rsa.PublicKey).E = 3
+	keys, _ = jwkClient.Key("ABCDEFG")
+	assert(t, keys[0].Key.(*rsa.PublicKey).E == 65537, "cached key material should not be mutated through Key")
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "cached key set should not be mutated")
+
+	_, err = jwkClient.Key("GFEDCBA")
+	assert(t, errors.Is(err, ErrKeyNotFound), fmt.Sprintf("it should return ErrKeyNotFound not %s", err))
+
+	// readers are not blocked while a refresh waits on the network
+	refreshed := make(chan struct{})
+	go func() {
+		jwkClient.ForceRefresh()
+		close(refreshed)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if keys, err := jwkClient.Key("ABCDEFG"); err != nil || len(keys) != 1 {
+					t.Error("it should return cached key during refresh")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-refreshed:
+		t.Error("refresh should be waiting on the network")
+	case <-time.After(10 * time.Millisecond):
+	}
+	transport.release <- struct{}{}
+	<-refreshed
+
+	preloaded := *cloneRsaPublicKey(&rsaTestKey.PublicKey)
+	jwkClient.PreLoad("GFEDCBA", &preloaded)
+	preloaded.E = 3
+	keys, err = jwkClient.Key("GFEDCBA")
+	assert(t, err == nil && len(keys) == 1, fmt.Sprintf("it should return preloaded key %s", err))
+	assert(t, keys[0].Key.(*rsa.PublicKey).E == 65537, "preloaded key material should be copied")
+}
+
+func TestKeyPolicyConfig(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"VKOoRQ","e":"AQAB"}]}`
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockBodyTransport{body}}
+	err := jwkClient.Start()
+	assert(t, errors.Is(err, ErrWeakKey), fmt.Sprintf("it should reject weak key not %s", err))
+	jwkClient.Stop()
+
+	jwkClient, _ = NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.KeyPolicy = KeyPolicy{}
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockBodyTransport{body}}
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	jwkClient.Stop()
+
+	// weak keys are skipped without LenientDecoding
+	legacyKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	legacy, _ := json.Marshal(JSONWebKey{Key: &legacyKey.PublicKey, KeyID: "legacy"})
+	body = `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"},` + string(legacy) + `]}`
+	var skipped []KeyWarning
+	jwkClient, _ = NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.OnSkippedKey = func(w KeyWarning) {
+			skipped = append(skipped, w)
+		}
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockBodyTransport{body}}
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("weak key should not fail Start %s", err))
+	defer jwkClient.Stop()
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "it should skip weak key")
+	assert(t, len(skipped) == 1 && skipped[0].KeyID == "legacy" && errors.Is(skipped[0], ErrWeakKey), "it should report weak key")
+}
+
+type mockStatusTransport struct {
+	status int
+}
+
+func (t *mockStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Header:     make(http.Header),
+		Request:    req,
+		StatusCode: t.status,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func TestSubscribe(t *testing.T) {
+	rotated, _ := json.Marshal(JSONWebKeySet{Keys: []JSONWebKey{
+		{Key: &rsaTestKey.PublicKey, KeyID: "ABCDEFG"},
+		{Key: &ecTestKey256.PublicKey, KeyID: "GFEDCBA"},
+	}})
+	transport := &mockBodyTransport{`{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+
+	var diffs []*KeySetDiff
+	var errs []error
+	unsubscribe := jwkClient.Subscribe(Listener{
+		OnRefresh: func(old, current *JSONWebKeySet, diff *KeySetDiff) {
+			current.Keys = nil
+			diffs = append(diffs, diff)
+		},
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	jwkClient.Subscribe(Listener{})
+
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+	assert(t, len(diffs) == 1 && len(diffs[0].Added) == 1, "it should notify initial load")
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "listener should not mutate cached key set")
+
+	transport.body = string(rotated)
+	jwkClient.ForceRefresh()
+	assert(t, len(diffs) == 2, "it should notify refresh")
+	assert(t, len(diffs[1].Added) == 1 && diffs[1].Added[0].KeyID == "GFEDCBA", "it should report added key")
+	assert(t, len(diffs[1].Changed) == 1 && diffs[1].Changed[0].New.KeyID == "ABCDEFG", "it should report changed key")
+	assert(t, len(errs) == 0, "it should not notify error")
+
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockStatusTransport{http.StatusInternalServerError}}
+	jwkClient.ForceRefresh()
+	assert(t, len(diffs) == 2 && len(errs) == 1, "it should notify fetch failure")
+	assert(t, len(jwkClient.KeySet().Keys) == 2, "it should keep key set on fetch failure")
+
+	unsubscribe()
+	jwkClient.ForceRefresh()
+	assert(t, len(errs) == 1, "it should not notify after unsubscribe")
+}
+
+func TestListenerRefresh(t *testing.T) {
+	transport := &mockBodyTransport{`{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`}
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+
+	refreshes := 0
+	jwkClient.Subscribe(Listener{
+		OnRefresh: func(old, current *JSONWebKeySet, diff *KeySetDiff) {
+			refreshes++
+			if refreshes == 1 {
+				// listeners may refresh without deadlock
+				err := jwkClient.Refresh(context.Background())
+				assert(t, err == nil, fmt.Sprintf("fail to Refresh from listener %s", err))
+			}
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- jwkClient.Start() }()
+	select {
+	case err := <-done:
+		assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener calling Refresh should not deadlock")
+	}
+	defer jwkClient.Stop()
+	assert(t, refreshes == 2, "it should notify both refreshes")
+}
+
+type mockHeaderTransport struct {
+	mockBodyTransport
+	header http.Header
+}
+
+func (t *mockHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.mockBodyTransport.RoundTrip(req)
+	resp.Header = t.header
+	return resp, err
+}
+
+func TestCacheLifetime(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header http.Header
+		d      time.Duration
+		ok     bool
+	}{
+		{http.Header{}, 0, false},
+		{http.Header{"Cache-Control": {"public, max-age=3600"}}, time.Hour, true},
+		{http.Header{"Cache-Control": {`public, max-age="60"`}, "Age": {"20"}}, 40 * time.Second, true},
+		{http.Header{"Cache-Control": {"max-age=99999999999999999999"}}, 1 << 31 * time.Second, true},
+		{http.Header{"Cache-Control": {"max-age=60, no-cache"}}, 0, true},
+		{http.Header{"Cache-Control": {"No-Store"}}, 0, true},
+		{http.Header{"Cache-Control": {"max-age=oops"}}, 0, false},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Expires": {"Wed, 01 Jan 2020 02:00:00 GMT"}}, time.Minute, true},
+		{http.Header{"Expires": {"Wed, 01 Jan 2020 02:00:00 GMT"}}, 2 * time.Hour, true},
+		{http.Header{"Expires": {"Wed, 01 Jan 2020 02:00:00 GMT"}, "Date": {"Wed, 01 Jan 2020 01:30:00 GMT"}}, 30 * time.Minute, true},
+		{http.Header{"Expires": {"0"}}, 0, true},
+	}
+
+	for i, test := range tests {
+		d, ok := cacheLifetime(test.header, now)
+		assert(t, d == test.d && ok == test.ok, fmt.Sprintf("test %d: cacheLifetime returned %s %v", i, d, ok))
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	jwkClient, _ := NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.MinRefreshInterval = 0
+		return nil
+	})
+	now := time.Now()
+	tests := []struct {
+		expires  time.Time
+		interval time.Duration
+	}{
+		{time.Time{}, defaultCacheTimeout},
+		{now, 0},
+		{now.Add(-time.Second), 0},
+		{now.Add(time.Second), time.Second},
+		{now.Add(48 * time.Hour), defaultMaxRefresh},
+	}
+
+	for _, test := range tests {
+		interval := jwkClient.refreshInterval(test.expires, now)
+		assert(t, interval == test.interval, fmt.Sprintf("%s: it should refresh after %s not %s", test.expires, test.interval, interval))
+	}
+}
+
+func TestRefreshSchedule(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	transport := &mockHeaderTransport{mockBodyTransport{body}, http.Header{"Cache-Control": {"max-age=3600"}}}
+
+	jwkClient, _ := NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.MaxRefreshInterval = 30 * time.Minute
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	assert(t, jwkClient.NextRefresh().IsZero(), "refresh should not be scheduled before Start")
+
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	next := time.Until(jwkClient.NextRefresh())
+	assert(t, next > 29*time.Minute && next <= 30*time.Minute, fmt.Sprintf("max-age should be clamped to MaxRefreshInterval not %s", next))
+
+	transport.header = http.Header{"Cache-Control": {"no-cache"}}
+	jwkClient.ForceRefresh()
+	next = time.Until(jwkClient.NextRefresh())
+	assert(t, next > 59*time.Second && next <= time.Minute, fmt.Sprintf("no-cache should be clamped to MinRefreshInterval not %s", next))
+
+	transport.header = http.Header{}
+	jwkClient.ForceRefresh()
+	next = time.Until(jwkClient.NextRefresh())
+	assert(t, next > 9*time.Minute && next <= defaultCacheTimeout, fmt.Sprintf("it should fall back to CacheTimeout not %s", next))
+
+	jwkClient.Stop()
+	<-jwkClient.refreshChan
+	assert(t, jwkClient.NextRefresh().IsZero(), "refresh should not be scheduled after Stop")
+}
+
+type mockConditionalTransport struct {
+	mockBodyTransport
+	etag     string
+	requests []*http.Request
+}
+
+func (t *mockConditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	if t.etag != "" && req.Header.Get("If-None-Match") == t.etag {
+		return &http.Response{
+			Header:     http.Header{"Cache-Control": {"max-age=3600"}},
+			Request:    req,
+			StatusCode: http.StatusNotModified,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+	resp, err := t.mockBodyTransport.RoundTrip(req)
+	resp.Header.Set("ETag", t.etag)
+	resp.Header.Set("Last-Modified", "Wed, 01 Jan 2020 00:00:00 GMT")
+	return resp, err
+}
+
+func TestConditionalRequest(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	transport := &mockConditionalTransport{mockBodyTransport: mockBodyTransport{body}, etag: `"v1"`}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+
+	var diffs []*KeySetDiff
+	jwkClient.Subscribe(Listener{
+		OnRefresh: func(old, current *JSONWebKeySet, diff *KeySetDiff) {
+			diffs = append(diffs, diff)
+		},
+	})
+
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+	assert(t, transport.requests[0].Header.Get("If-None-Match") == "", "first request should not be conditional")
+
+	transport.body = "not modified"
+	jwkClient.ForceRefresh()
+	req := transport.requests[1]
+	assert(t, req.Header.Get("If-None-Match") == `"v1"`, "it should send If-None-Match")
+	assert(t, req.Header.Get("If-Modified-Since") == "Wed, 01 Jan 2020 00:00:00 GMT", "it should send If-Modified-Since")
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "it should keep key set on Not Modified")
+	assert(t, len(diffs) == 2 && diffs[1].Empty(), "it should notify refresh with empty diff")
+	next := time.Until(jwkClient.NextRefresh())
+	assert(t, next > 59*time.Minute, fmt.Sprintf("it should schedule from Not Modified headers not %s", next))
+
+	transport.etag = ""
+	jwkClient.ForceRefresh()
+	assert(t, len(diffs) == 2, "it should fail to decode modified body")
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "it should keep key set on fetch failure")
+}
+
+type mockCountingTransport struct {
+	http.RoundTripper
+	count int32
+}
+
+func (t *mockCountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.count, 1)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestGetKey(t *testing.T) {
+	key := `{"kty":"RSA","kid":"%s","n":"` + testCertificateModulus + `","e":"AQAB"}`
+	body := &mockBodyTransport{`{"keys":[` + fmt.Sprintf(key, "ABCDEFG") + `]}`}
+	transport := &mockCountingTransport{RoundTripper: body}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	_, err := jwkClient.GetKey(context.Background(), "ABCDEFG")
+	assert(t, errors.Is(err, ErrKeyNotFound) && transport.count == 0, "it should not refresh before Start")
+
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	jwkey, err := jwkClient.GetKey(context.Background(), "ABCDEFG")
+	assert(t, err == nil && jwkey.KeyID == "ABCDEFG", fmt.Sprintf("it should return cached key %s", err))
+	assert(t, transport.count == 1, "it should not refresh for cached key")
+
+	body.body = `{"keys":[` + fmt.Sprintf(key, "ABCDEFG") + `,` + fmt.Sprintf(key, "GFEDCBA") + `]}`
+	jwkey, err = jwkClient.GetKey(context.Background(), "GFEDCBA")
+	assert(t, err == nil && jwkey.KeyID == "GFEDCBA", fmt.Sprintf("it should refresh for unknown key %s", err))
+	assert(t, transport.count == 2, "it should refresh once for unknown key")
+
+	_, err = jwkClient.GetKey(context.Background(), "unknown")
+	assert(t, errors.Is(err, ErrKeyNotFound), fmt.Sprintf("it should return ErrKeyNotFound not %s", err))
+	assert(t, transport.count == 2, "refresh should be rate limited")
+
+	jwkClient.config.UnknownKeyRefreshInterval = 0
+	_, err = jwkClient.GetKey(context.Background(), "unknown")
+	assert(t, errors.Is(err, ErrKeyNotFound) && transport.count == 3, "it should refresh for unknown key")
+	_, err = jwkClient.GetKey(context.Background(), "unknown")
+	assert(t, errors.Is(err, ErrKeyNotFound) && transport.count == 3, "unknown key should be negatively cached")
+}
+
+func TestGetKeyConcurrentRefresh(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	blocking := &mockBlockingTransport{mockBodyTransport{body}, make(chan struct{}, 1)}
+	blocking.release <- struct{}{}
+	transport := &mockCountingTransport{RoundTripper: blocking}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := jwkClient.GetKey(context.Background(), "GFEDCBA"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("it should return ErrKeyNotFound not %s", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = jwkClient.GetKey(ctx, "GFEDCBA")
+	assert(t, errors.Is(err, context.DeadlineExceeded), fmt.Sprintf("it should return ctx error not %s", err))
+
+	blocking.release <- struct{}{}
+	wg.Wait()
+	assert(t, atomic.LoadInt32(&transport.count) == 2, "concurrent lookups should share one refresh")
+}
+
+type mockContextTransport struct {
+	mockBodyTransport
+	block chan struct{}
+}
+
+func (t *mockContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-t.block:
+		return t.mockBodyTransport.RoundTrip(req)
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	transport := &mockContextTransport{mockBodyTransport{body}, make(chan struct{})}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	err := jwkClient.Refresh(context.Background())
+	assert(t, err != nil, "Refresh should fail before Start")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = jwkClient.StartContext(ctx)
+	assert(t, errors.Is(err, context.DeadlineExceeded), fmt.Sprintf("StartContext should return ctx error not %s", err))
+	assert(t, jwkClient.NextRefresh().IsZero(), "cancelled fetch should not schedule refresh")
+
+	jwkClient, _ = NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+	close(transport.block)
+	err = jwkClient.StartContext(context.Background())
+	assert(t, err == nil, fmt.Sprintf("fail to StartContext %s", err))
+
+	err = jwkClient.Refresh(context.Background())
+	assert(t, err == nil, fmt.Sprintf("fail to Refresh %s", err))
+
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockStatusTransport{http.StatusInternalServerError}}
+	err = jwkClient.Refresh(context.Background())
+	assert(t, err != nil, "Refresh should return fetch error")
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "it should keep key set on fetch failure")
+
+	err = jwkClient.Close(context.Background())
+	assert(t, err == nil, fmt.Sprintf("fail to Close %s", err))
+	assert(t, jwkClient.NextRefresh().IsZero(), "Close should wait for periodic update to exit")
+	err = jwkClient.Close(context.Background())
+	assert(t, err != nil, "Close should fail on closed client")
+	err = jwkClient.Refresh(context.Background())
+	assert(t, err != nil, "Refresh should fail on closed client")
+}
+
+func TestCloseCancelsFetch(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	transport := &mockContextTransport{mockBodyTransport{body}, make(chan struct{})}
+
+	jwkClient, _ := NewClient("http://andy2046.io")
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+
+	started := make(chan error)
+	go func() {
+		started <- jwkClient.Start()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	err := jwkClient.Close(context.Background())
+	assert(t, err == nil, fmt.Sprintf("fail to Close %s", err))
+	err = <-started
+	assert(t, errors.Is(err, context.Canceled), fmt.Sprintf("Close should cancel in-flight fetch not %s", err))
+}
+
+type mockSequenceTransport struct {
+	mockBodyTransport
+	statuses []int
+	header   http.Header
+}
+
+func (t *mockSequenceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.statuses) == 0 {
+		return t.mockBodyTransport.RoundTrip(req)
+	}
+	status := t.statuses[0]
+	t.statuses = t.statuses[1:]
+	return &http.Response{
+		Header:     t.header,
+		Request:    req,
+		StatusCode: status,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func TestRetryPolicyConfig(t *testing.T) {
+	body := `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+	retryPolicy := func(config *ClientConfig) error {
+		config.RetryPolicy = RetryPolicy{InitialDelay: time.Millisecond, MaxDelay: time.Minute, Multiplier: 2, MaxAttempts: 3}
+		return nil
+	}
+
+	transport := &mockSequenceTransport{mockBodyTransport{body}, []int{503, 429}, http.Header{"Retry-After": {"0"}}}
+	counter := &mockCountingTransport{RoundTripper: transport}
+	jwkClient, _ := NewClient("http://andy2046.io", retryPolicy)
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: counter}
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("Start should retry %s", err))
+	assert(t, counter.count == 3, fmt.Sprintf("Start should make 3 attempts not %d", counter.count))
+
+	// failed periodic update retries after the retry delay
+	jwkClient.config.RetryPolicy.InitialDelay = 30 * time.Second
+	transport.statuses = []int{500}
+	jwkClient.ForceRefresh()
+	next := time.Until(jwkClient.NextRefresh())
+	assert(t, next > 29*time.Second && next <= 30*time.Second, fmt.Sprintf("it should retry after InitialDelay not %s", next))
+
+	// failed on-demand refresh keeps the schedule
+	scheduled := jwkClient.NextRefresh()
+	transport.statuses = []int{500}
+	err = jwkClient.Refresh(context.Background())
+	assert(t, err != nil, "Refresh should return fetch error")
+	assert(t, jwkClient.NextRefresh().Equal(scheduled), "failed Refresh should keep the schedule")
+	jwkClient.Stop()
+
+	transport = &mockSequenceTransport{mockBodyTransport{body}, []int{503, 503, 503}, http.Header{}}
+	counter = &mockCountingTransport{RoundTripper: transport}
+	jwkClient, _ = NewClient("http://andy2046.io", retryPolicy)
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: counter}
+	err = jwkClient.Start()
+	var httpErr *HTTPError
+	assert(t, errors.As(err, &httpErr) && httpErr.StatusCode == 503, fmt.Sprintf("Start should return HTTPError not %s", err))
+	assert(t, counter.count == 3, fmt.Sprintf("Start should stop after MaxAttempts not %d", counter.count))
+
+	transport = &mockSequenceTransport{mockBodyTransport{body}, []int{404}, http.Header{}}
+	counter = &mockCountingTransport{RoundTripper: transport}
+	jwkClient, _ = NewClient("http://andy2046.io", retryPolicy)
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: counter}
+	err = jwkClient.Start()
+	assert(t, err != nil && counter.count == 1, "Start should not retry permanent error")
+}
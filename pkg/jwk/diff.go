@@ -0,0 +1,180 @@
+package jwk
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type (
+	// KeySetDiff lists the keys added, removed and changed between two key sets.
+	KeySetDiff struct {
+		Added   []JSONWebKey
+		Removed []JSONWebKey
+		Changed []KeyChange
+	}
+
+	// KeyChange is a key whose key ID is kept with different key material.
+	KeyChange struct {
+		Old JSONWebKey
+		New JSONWebKey
+	}
+
+	// keySummary describes a key without its material for auditing.
+	keySummary struct {
+		KeyID      string `json:"kid,omitempty"`
+		KeyType    string `json:"kty,omitempty"`
+		Algorithm  string `json:"alg,omitempty"`
+		Use        string `json:"use,omitempty"`
+		Thumbprint string `json:"thumbprint,omitempty"`
+	}
+
+	keyChangeSummary struct {
+		Old keySummary `json:"old"`
+		New keySummary `json:"new"`
+	}
+)
+
+// Diff returns the keys added, removed and changed in newer compared to
+// the given set. Keys are matched by key ID, and compared by RFC 7638
+// thumbprint. Keys without key ID are either added or removed.
+func (set *JSONWebKeySet) Diff(newer *JSONWebKeySet) *KeySetDiff {
+	var oldKeys, newKeys []JSONWebKey
+	if set != nil {
+		oldKeys = set.Keys
+	}
+	if newer != nil {
+		newKeys = newer.Keys
+	}
+
+	var kids []string
+	oldByKid := make(map[string][]JSONWebKey)
+	newByKid := make(map[string][]JSONWebKey)
+	for _, key := range oldKeys {
+		if _, ok := oldByKid[key.KeyID]; !ok {
+			kids = append(kids, key.KeyID)
+		}
+		oldByKid[key.KeyID] = append(oldByKid[key.KeyID], key)
+	}
+	for _, key := range newKeys {
+		if _, ok := oldByKid[key.KeyID]; !ok {
+			if _, ok := newByKid[key.KeyID]; !ok {
+				kids = append(kids, key.KeyID)
+			}
+		}
+		newByKid[key.KeyID] = append(newByKid[key.KeyID], key)
+	}
+
+	diff := &KeySetDiff{}
+	for _, kid := range kids {
+		removed, added := unmatchedKeys(oldByKid[kid], newByKid[kid])
+		if kid != "" {
+			for len(removed) > 0 && len(added) > 0 {
+				diff.Changed = append(diff.Changed, KeyChange{Old: removed[0], New: added[0]})
+				removed, added = removed[1:], added[1:]
+			}
+		}
+		diff.Removed = append(diff.Removed, removed...)
+		diff.Added = append(diff.Added, added...)
+	}
+
+	return diff
+}
+
+// Empty returns true if no key was added, removed or changed.
+func (diff KeySetDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// MarshalJSON returns JSON representation of the given diff for auditing,
+// keys are described by key ID, type, algorithm, use and thumbprint.
+func (diff KeySetDiff) MarshalJSON() ([]byte, error) {
+	raw := struct {
+		Added   []keySummary       `json:"added"`
+		Removed []keySummary       `json:"removed"`
+		Changed []keyChangeSummary `json:"changed"`
+	}{
+		Added:   make([]keySummary, len(diff.Added)),
+		Removed: make([]keySummary, len(diff.Removed)),
+		Changed: make([]keyChangeSummary, len(diff.Changed)),
+	}
+	for i, key := range diff.Added {
+		raw.Added[i] = summarize(key)
+	}
+	for i, key := range diff.Removed {
+		raw.Removed[i] = summarize(key)
+	}
+	for i, change := range diff.Changed {
+		raw.Changed[i] = keyChangeSummary{Old: summarize(change.Old), New: summarize(change.New)}
+	}
+
+	return json.Marshal(raw)
+}
+
+// String returns a one-line summary of added (+), removed (-)
+// and changed (~) keys.
+func (diff KeySetDiff) String() string {
+	var parts []string
+	for _, key := range diff.Added {
+		parts = append(parts, fmt.Sprintf("+%s", summarize(key)))
+	}
+	for _, key := range diff.Removed {
+		parts = append(parts, fmt.Sprintf("-%s", summarize(key)))
+	}
+	for _, change := range diff.Changed {
+		parts = append(parts, fmt.Sprintf("~%s", summarize(change.New)))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func (s keySummary) String() string {
+	return fmt.Sprintf("%s(%s %s)", s.KeyID, s.KeyType, s.Thumbprint)
+}
+
+// unmatchedKeys returns old and new keys without a key of identical thumbprint.
+func unmatchedKeys(oldKeys, newKeys []JSONWebKey) ([]JSONWebKey, []JSONWebKey) {
+	matched := make([]bool, len(newKeys))
+	var removed []JSONWebKey
+
+	for _, oldKey := range oldKeys {
+		found := false
+		oldTp := keyThumbprint(oldKey)
+		for i, newKey := range newKeys {
+			if !matched[i] && oldTp != "" && oldTp == keyThumbprint(newKey) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			removed = append(removed, oldKey)
+		}
+	}
+
+	var added []JSONWebKey
+	for i, newKey := range newKeys {
+		if !matched[i] {
+			added = append(added, newKey)
+		}
+	}
+	return removed, added
+}
+
+// keyThumbprint returns the base64url SHA-256 thumbprint of key, empty on error.
+func keyThumbprint(key JSONWebKey) string {
+	tp, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return newBuffer(tp).base64()
+}
+
+func summarize(key JSONWebKey) keySummary {
+	return keySummary{
+		KeyID:      key.KeyID,
+		KeyType:    key.KeyType(),
+		Algorithm:  key.Algorithm,
+		Use:        key.Use,
+		Thumbprint: keyThumbprint(key),
+	}
+}
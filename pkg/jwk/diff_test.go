@@ -0,0 +1,74 @@
+package jwk
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	rsaKey := JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "rsa", Algorithm: "RS256"}
+	ecKey := JSONWebKey{Key: &ecTestKey256.PublicKey, KeyID: "ec", Algorithm: "ES256"}
+	rotatedKey := JSONWebKey{Key: &ecTestKey384.PublicKey, KeyID: "ec", Algorithm: "ES384"}
+	edKey := JSONWebKey{Key: ed25519TestPublicKey, KeyID: "ed", Algorithm: "EdDSA"}
+	anonymousKey := JSONWebKey{Key: x25519TestKey.PublicKey()}
+
+	older := &JSONWebKeySet{Keys: []JSONWebKey{rsaKey, ecKey, anonymousKey}}
+	newer := &JSONWebKeySet{Keys: []JSONWebKey{rotatedKey, rsaKey, edKey, anonymousKey}}
+
+	diff := older.Diff(newer)
+	assert(t, !diff.Empty(), "diff should not be empty")
+	assert(t, len(diff.Added) == 1 && diff.Added[0].KeyID == "ed", fmt.Sprintf("it should add ed key, got %v", diff))
+	assert(t, len(diff.Removed) == 0, fmt.Sprintf("it should remove no key, got %v", diff))
+	assert(t, len(diff.Changed) == 1 && diff.Changed[0].Old.Algorithm == "ES256" &&
+		diff.Changed[0].New.Algorithm == "ES384", fmt.Sprintf("it should change ec key, got %v", diff))
+
+	reverse := newer.Diff(older)
+	assert(t, len(reverse.Removed) == 1 && reverse.Removed[0].KeyID == "ed", "it should remove ed key")
+	assert(t, len(reverse.Added) == 0 && len(reverse.Changed) == 1, "it should change ec key")
+
+	assert(t, older.Diff(older).Empty(), "diff with itself should be empty")
+
+	metadataOnly := &JSONWebKeySet{Keys: []JSONWebKey{{Key: &rsaTestKey.PublicKey, KeyID: "rsa", Use: "sig"}}}
+	diff = (&JSONWebKeySet{Keys: []JSONWebKey{rsaKey}}).Diff(metadataOnly)
+	assert(t, diff.Empty(), "same key material should not be a change")
+
+	diff = (&JSONWebKeySet{Keys: []JSONWebKey{anonymousKey}}).Diff(&JSONWebKeySet{Keys: []JSONWebKey{{Key: ed25519TestPublicKey}}})
+	assert(t, len(diff.Added) == 1 && len(diff.Removed) == 1 && len(diff.Changed) == 0,
+		"keys without kid should be added and removed")
+
+	diff = (*JSONWebKeySet)(nil).Diff(older)
+	assert(t, len(diff.Added) == 3, "all keys should be added to a nil set")
+}
+
+func TestDiffMarshalJSON(t *testing.T) {
+	older := &JSONWebKeySet{Keys: []JSONWebKey{{Key: &ecTestKey256.PublicKey, KeyID: "ec"}}}
+	newer := &JSONWebKeySet{Keys: []JSONWebKey{{Key: &ecTestKey384.PublicKey, KeyID: "ec"}, {Key: make([]byte, 32), KeyID: "oct"}}}
+
+	jsonbar, err := json.Marshal(older.Diff(newer))
+	assert(t, err == nil, fmt.Sprintf("problem marshalling diff %s", err))
+
+	var audit struct {
+		Added []struct {
+			KeyID      string `json:"kid"`
+			KeyType    string `json:"kty"`
+			Thumbprint string `json:"thumbprint"`
+		} `json:"added"`
+		Removed []json.RawMessage `json:"removed"`
+		Changed []struct {
+			Old struct {
+				Thumbprint string `json:"thumbprint"`
+			} `json:"old"`
+			New struct {
+				Thumbprint string `json:"thumbprint"`
+			} `json:"new"`
+		} `json:"changed"`
+	}
+	err = json.Unmarshal(jsonbar, &audit)
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling diff %s", err))
+	assert(t, len(audit.Added) == 1 && audit.Added[0].KeyID == "oct" && audit.Added[0].KeyType == "oct",
+		fmt.Sprintf("unexpected audit %s", jsonbar))
+	assert(t, len(audit.Removed) == 0, "removed should be empty")
+	assert(t, len(audit.Changed) == 1 && audit.Changed[0].Old.Thumbprint != audit.Changed[0].New.Thumbprint,
+		fmt.Sprintf("unexpected audit %s", jsonbar))
+}
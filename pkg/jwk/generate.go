@@ -0,0 +1,70 @@
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+)
+
+const defaultRSAKeySize = 2048
+
+// Generate returns a new private key for the signing algorithm alg,
+// ready to publish with alg and use set, and the RFC 7638 thumbprint as key ID.
+// bits is the size of RSA and HMAC keys, zero picks the default
+// of 2048 bits for RSA and the hash size for HMAC, it is ignored otherwise.
+func Generate(alg string, bits int) (*JSONWebKey, error) {
+	var (
+		k   interface{}
+		err error
+	)
+
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		if bits == 0 {
+			bits = defaultRSAKeySize
+		}
+		k, err = rsa.GenerateKey(rand.Reader, bits)
+	case "ES256":
+		k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		k, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		k, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, k, err = ed25519.GenerateKey(rand.Reader)
+	case "HS256", "HS384", "HS512":
+		k, err = generateOctKey(alg, bits)
+	default:
+		err = fmt.Errorf("Unsupported algorithm '%s'", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newKey(k)
+	if err != nil {
+		return nil, err
+	}
+	key.Algorithm = alg
+	key.Use = "sig"
+	return key, nil
+}
+
+func generateOctKey(alg string, bits int) ([]byte, error) {
+	size := minSymmetricKeySize(alg)
+	if bits != 0 {
+		if bits%8 != 0 || bits/8 < size {
+			return nil, fmt.Errorf("Invalid key size %d for algorithm '%s', minimum is %d bits", bits, alg, size*8)
+		}
+		size = bits / 8
+	}
+
+	k := make([]byte, size)
+	if _, err := rand.Read(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
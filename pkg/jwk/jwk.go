@@ -1,61 +1,153 @@
 package jwk
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"net/url"
 	"reflect"
 )
 
+var (
+	// ErrCertificateKeyMismatch is returned when the x5c leaf certificate
+	// does not hold the public key of the JWK.
+	ErrCertificateKeyMismatch = errors.New("Certificate public key does not match JWK key")
+)
+
 type (
 	rawJSONWebKey struct {
-		Use string      `json:"use,omitempty"`
-		Kty string      `json:"kty,omitempty"`
-		Kid string      `json:"kid,omitempty"`
-		Alg string      `json:"alg,omitempty"`
-		N   *byteBuffer `json:"n,omitempty"`
-		E   *byteBuffer `json:"e,omitempty"`
-		X5c []string    `json:"x5c,omitempty"` // Certificates
+		Use     string      `json:"use,omitempty"`
+		KeyOps  []string    `json:"key_ops,omitempty"`
+		Kty     string      `json:"kty,omitempty"`
+		Kid     string      `json:"kid,omitempty"`
+		Alg     string      `json:"alg,omitempty"`
+		Crv     string      `json:"crv,omitempty"`
+		N       *byteBuffer `json:"n,omitempty"`
+		E       *byteBuffer `json:"e,omitempty"`
+		X       *byteBuffer `json:"x,omitempty"`
+		Y       *byteBuffer `json:"y,omitempty"`
+		K       *byteBuffer `json:"k,omitempty"`
+		D       *byteBuffer `json:"d,omitempty"`
+		P       *byteBuffer `json:"p,omitempty"`
+		Q       *byteBuffer `json:"q,omitempty"`
+		Dp      *byteBuffer `json:"dp,omitempty"`
+		Dq      *byteBuffer `json:"dq,omitempty"`
+		Qi      *byteBuffer `json:"qi,omitempty"`
+		X5u     string      `json:"x5u,omitempty"`      // Certificates URL
+		X5c     []string    `json:"x5c,omitempty"`      // Certificates
+		X5t     *byteBuffer `json:"x5t,omitempty"`      // Certificate SHA-1 thumbprint
+		X5tS256 *byteBuffer `json:"x5t#S256,omitempty"` // Certificate SHA-256 thumbprint
 	}
 
-	// JSONWebKey represents a RSA public key in JWK format.
+	// JSONWebKey represents a RSA, EC or OKP public or private key,
+	// or a symmetric key as []byte, in JWK format.
 	JSONWebKey struct {
-		Key          interface{}
-		Certificates []*x509.Certificate
-		KeyID        string
-		Algorithm    string
-		Use          string
+		Key                         interface{}
+		Certificates                []*x509.Certificate
+		CertificatesURL             *url.URL
+		CertificateThumbprintSHA1   []byte
+		CertificateThumbprintSHA256 []byte
+		KeyID                       string
+		Algorithm                   string
+		Use                         string
+		KeyOps                      []string
+		// Extra holds unknown and extension members, emitted back as is.
+		Extra map[string]json.RawMessage
+		// members records the order of members as parsed.
+		members []string
 	}
 
 	// JSONWebKeySet represents a JWK Set object.
 	JSONWebKeySet struct {
 		Keys []JSONWebKey `json:"keys"`
+		// Extra holds unknown and extension members, emitted back as is.
+		Extra map[string]json.RawMessage `json:"-"`
+		// members records the order of members as parsed.
+		members []string
+	}
+
+	rawJSONWebKeySet struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+
+	// KeyWarning reports a key skipped while decoding a JWK Set leniently.
+	KeyWarning struct {
+		Index int // Position of the key in the set
+		KeyID string
+		Err   error
 	}
 )
 
 // MarshalJSON returns JSON representation of the given key.
 func (key JSONWebKey) MarshalJSON() ([]byte, error) {
-	var raw *rawJSONWebKey
+	var (
+		raw *rawJSONWebKey
+		err error
+	)
 
 	switch k := key.Key.(type) {
 	case *rsa.PublicKey:
 		raw = fromRsaPublicKey(k)
+	case *rsa.PrivateKey:
+		raw, err = fromRsaPrivateKey(k)
+	case *ecdsa.PublicKey:
+		raw, err = fromEcPublicKey(k)
+	case *ecdsa.PrivateKey:
+		raw, err = fromEcPrivateKey(k)
+	case ed25519.PublicKey:
+		raw, err = fromEd25519PublicKey(k)
+	case ed25519.PrivateKey:
+		raw, err = fromEd25519PrivateKey(k)
+	case *ecdh.PublicKey:
+		raw, err = fromX25519PublicKey(k)
+	case *ecdh.PrivateKey:
+		raw, err = fromX25519PrivateKey(k)
+	case []byte:
+		raw, err = fromOctKey(k)
 	default:
 		return nil, fmt.Errorf("Unknown key type '%s'", reflect.TypeOf(k))
 	}
 
+	if err != nil {
+		return nil, err
+	}
+
+	if err = key.checkConsistency(); err != nil {
+		return nil, err
+	}
+
 	raw.Kid = key.KeyID
 	raw.Alg = key.Algorithm
 	raw.Use = key.Use
+	raw.KeyOps = key.KeyOps
+	raw.X5t = newBuffer(key.CertificateThumbprintSHA1)
+	raw.X5tS256 = newBuffer(key.CertificateThumbprintSHA256)
+	if key.CertificatesURL != nil {
+		raw.X5u = key.CertificatesURL.String()
+	}
 
 	for _, cert := range key.Certificates {
 		raw.X5c = append(raw.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
 	}
 
-	return json.Marshal(raw)
+	data, err := marshalJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeMembers(data, key.Extra, key.members, jwkMembers)
 }
 
 // UnmarshalJSON returns the key from JSON representation.
@@ -66,10 +158,33 @@ func (key *JSONWebKey) UnmarshalJSON(data []byte) (err error) {
 		return
 	}
 
+	extra, members, err := splitMembers(data, jwkMembers)
+	if err != nil {
+		return
+	}
+
 	var k interface{}
 	switch raw.Kty {
 	case "RSA":
-		k, err = raw.rsaPublicKey()
+		if raw.D != nil {
+			k, err = raw.rsaPrivateKey()
+		} else {
+			k, err = raw.rsaPublicKey()
+		}
+	case "EC":
+		if raw.D != nil {
+			k, err = raw.ecPrivateKey()
+		} else {
+			k, err = raw.ecPublicKey()
+		}
+	case "OKP":
+		if raw.D != nil {
+			k, err = raw.okpPrivateKey()
+		} else {
+			k, err = raw.okpPublicKey()
+		}
+	case "oct":
+		k, err = raw.octKey()
 	default:
 		err = fmt.Errorf("Unknown json web key type '%s'", raw.Kty)
 	}
@@ -77,11 +192,31 @@ func (key *JSONWebKey) UnmarshalJSON(data []byte) (err error) {
 		return
 	}
 
-	*key = JSONWebKey{Key: k, KeyID: raw.Kid, Algorithm: raw.Alg, Use: raw.Use}
+	*key = JSONWebKey{
+		Key:                         k,
+		KeyID:                       raw.Kid,
+		Algorithm:                   raw.Alg,
+		Use:                         raw.Use,
+		KeyOps:                      raw.KeyOps,
+		CertificateThumbprintSHA1:   raw.X5t.bytes(),
+		CertificateThumbprintSHA256: raw.X5tS256.bytes(),
+		Extra:                       extra,
+		members:                     members,
+	}
 	key.Certificates, err = parseCertificateChain(raw.X5c)
 	if err != nil {
 		return fmt.Errorf("Fail to unmarshal x5c field: %s", err)
 	}
+	if raw.X5u != "" {
+		key.CertificatesURL, err = url.Parse(raw.X5u)
+		if err != nil {
+			return fmt.Errorf("Fail to unmarshal x5u field: %s", err)
+		}
+	}
+
+	if err = key.checkConsistency(); err != nil {
+		return
+	}
 
 	return
 }
@@ -93,9 +228,21 @@ func (key *JSONWebKey) Thumbprint(hash crypto.Hash) ([]byte, error) {
 		err   error
 	)
 
-	switch k := key.Key.(type) {
+	switch k := publicKey(key.Key).(type) {
 	case *rsa.PublicKey:
 		input, err = rsaThumbprintInput(k.N, k.E)
+	case *ecdsa.PublicKey:
+		input, err = ecThumbprintInput(k.Curve, k.X, k.Y)
+	case ed25519.PublicKey:
+		input, err = okpThumbprintInput("Ed25519", k)
+	case *ecdh.PublicKey:
+		if k.Curve() != ecdh.X25519() {
+			err = fmt.Errorf("Unsupported ECDH curve, only X25519 is supported")
+		} else {
+			input, err = okpThumbprintInput("X25519", k.Bytes())
+		}
+	case []byte:
+		input, err = octThumbprintInput(k)
 	default:
 		err = fmt.Errorf("Unknown key type '%s'", reflect.TypeOf(k))
 	}
@@ -109,22 +256,214 @@ func (key *JSONWebKey) Thumbprint(hash crypto.Hash) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
-// Valid checks the given key.
+// Valid checks the given key, without key strength requirements.
+// Use Validate to find out why a key is invalid.
 func (key *JSONWebKey) Valid() bool {
-	if key.Key == nil {
-		return false
+	return key.Validate(KeyPolicy{}) == nil
+}
+
+// checkConsistency checks that the key parameters do not contradict each other.
+func (key *JSONWebKey) checkConsistency() error {
+	if l := len(key.CertificateThumbprintSHA1); l > 0 && l != sha1.Size {
+		return key.invalid(ErrInvalidParameter, "x5t expected %d bytes got %d", sha1.Size, l)
+	}
+	if l := len(key.CertificateThumbprintSHA256); l > 0 && l != sha256.Size {
+		return key.invalid(ErrInvalidParameter, "x5t#S256 expected %d bytes got %d", sha256.Size, l)
 	}
 
-	switch k := key.Key.(type) {
-	case *rsa.PublicKey:
-		if k.N == nil || k.E == 0 {
-			return false
+	if len(key.Certificates) > 0 {
+		leaf := key.Certificates[0].Raw
+		sha1sum := sha1.Sum(leaf)
+		if len(key.CertificateThumbprintSHA1) > 0 &&
+			!bytes.Equal(key.CertificateThumbprintSHA1, sha1sum[:]) {
+			return key.invalid(ErrInvalidParameter, "x5t does not match the SHA-1 thumbprint of the x5c certificate")
 		}
+		sha256sum := sha256.Sum256(leaf)
+		if len(key.CertificateThumbprintSHA256) > 0 &&
+			!bytes.Equal(key.CertificateThumbprintSHA256, sha256sum[:]) {
+			return key.invalid(ErrInvalidParameter, "x5t#S256 does not match the SHA-256 thumbprint of the x5c certificate")
+		}
+	}
+
+	seen := make(map[string]bool, len(key.KeyOps))
+	for _, op := range key.KeyOps {
+		if seen[op] {
+			return key.invalid(ErrInvalidParameter, "key_ops has duplicate value '%s'", op)
+		}
+		seen[op] = true
+
+		if uses, ok := keyOpsUse[op]; ok && key.Use != "" && key.Use != uses {
+			return key.invalid(ErrUseConflict, "key_ops '%s' contradicts use '%s'", op, key.Use)
+		}
+	}
+
+	return nil
+}
+
+// VerifyCertificates verifies the x5c certificate chain of the given key
+// with opts, checking that the leaf certificate holds the public key of the
+// given key. Certificates following the leaf are used as intermediates.
+// It returns the verified chains.
+func (key *JSONWebKey) VerifyCertificates(opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	if len(key.Certificates) == 0 {
+		return nil, fmt.Errorf("Missing x5c certificate chain")
+	}
+
+	pub, ok := publicKey(key.Key).(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	if !ok || key.Public().Key == nil {
+		return nil, fmt.Errorf("Unknown key type '%s'", reflect.TypeOf(key.Key))
+	}
+
+	leaf := key.Certificates[0]
+	if !pub.Equal(leaf.PublicKey) {
+		return nil, ErrCertificateKeyMismatch
+	}
+
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	} else {
+		opts.Intermediates = opts.Intermediates.Clone()
+	}
+	for _, cert := range key.Certificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	return leaf.Verify(opts)
+}
+
+// Public returns the public JWK of the given key, with identical
+// key ID, algorithm, use and certificates, and key_ops mapped to
+// their public counterparts, e.g. sign to verify.
+// An empty JSONWebKey is returned for symmetric keys.
+func (key *JSONWebKey) Public() JSONWebKey {
+	pub := publicKey(key.Key)
+	if _, ok := pub.([]byte); ok || pub == nil {
+		return JSONWebKey{}
+	}
+
+	ret := key.clone()
+	ret.Key = pub
+	ret.KeyOps = publicKeyOps(key.KeyOps)
+	return ret
+}
+
+// IsPublic returns true if the given key holds no secret material.
+func (key *JSONWebKey) IsPublic() bool {
+	switch key.Key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey:
+		return true
 	default:
 		return false
 	}
+}
+
+// MarshalJSON returns JSON representation of the given key set.
+// It refuses to serialize private or symmetric keys so that secrets are not
+// published by accident, use MarshalSecretJSON for sets holding secrets on purpose.
+func (set JSONWebKeySet) MarshalJSON() ([]byte, error) {
+	for _, key := range set.Keys {
+		if key.Key != nil && !key.IsPublic() {
+			return nil, fmt.Errorf("Refusing to marshal private or symmetric key '%s' in a public key set", key.KeyID)
+		}
+	}
+
+	return set.MarshalSecretJSON()
+}
+
+// MarshalSecretJSON returns JSON representation of the given key set,
+// including private and symmetric keys.
+func (set JSONWebKeySet) MarshalSecretJSON() ([]byte, error) {
+	data, err := marshalJSON(rawJSONWebKeySet{Keys: set.Keys})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeMembers(data, set.Extra, set.members, jwksMembers)
+}
+
+// UnmarshalJSON returns the key set from JSON representation.
+func (set *JSONWebKeySet) UnmarshalJSON(data []byte) error {
+	var raw rawJSONWebKeySet
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	extra, members, err := splitMembers(data, jwksMembers)
+	if err != nil {
+		return err
+	}
+
+	*set = JSONWebKeySet{Keys: raw.Keys, Extra: extra, members: members}
+	return nil
+}
 
-	return true
+// ParseKeySetLenient returns the key set from JSON representation,
+// skipping unsupported or malformed keys as RFC 7517 section 5 requires.
+// Skipped keys are reported as warnings, an error is returned only if
+// data is not a JWK Set at all.
+func ParseKeySetLenient(data []byte) (*JSONWebKeySet, []KeyWarning, error) {
+	return parseKeySet(data, true, nil)
+}
+
+// parseKeySet returns the key set from JSON representation, keys failing
+// to decode are skipped if lenient, otherwise an error is returned.
+// Keys failing check are skipped, unless every key fails.
+func parseKeySet(data []byte, lenient bool, check func(*JSONWebKey) error) (*JSONWebKeySet, []KeyWarning, error) {
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+
+	extra, members, err := splitMembers(data, jwksMembers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := &JSONWebKeySet{Extra: extra, members: members}
+	var warnings []KeyWarning
+	var checkErr error
+	for i, rawKey := range raw.Keys {
+		var key JSONWebKey
+		err := key.UnmarshalJSON(rawKey)
+		checked := err == nil && check != nil
+		if checked {
+			err = check(&key)
+		}
+		if err != nil {
+			var header struct {
+				Kid string `json:"kid"`
+			}
+			json.Unmarshal(rawKey, &header)
+			if !lenient && !checked {
+				return nil, nil, fmt.Errorf("Rejected key %d (kid '%s'): %w", i, header.Kid, err)
+			}
+			if checked && checkErr == nil {
+				checkErr = fmt.Errorf("Rejected key %d (kid '%s'): %w", i, header.Kid, err)
+			}
+			warnings = append(warnings, KeyWarning{Index: i, KeyID: header.Kid, Err: err})
+			continue
+		}
+		set.Keys = append(set.Keys, key)
+	}
+
+	if len(set.Keys) == 0 && checkErr != nil {
+		return nil, nil, checkErr
+	}
+	return set, warnings, nil
+}
+
+// Error returns the skipped key and the reason.
+func (w KeyWarning) Error() string {
+	return fmt.Sprintf("Skipped key %d (kid '%s'): %s", w.Index, w.KeyID, w.Err)
+}
+
+// Unwrap returns the error that caused the key to be skipped.
+func (w KeyWarning) Unwrap() error {
+	return w.Err
 }
 
 // Key returns keys by key ID.
@@ -144,8 +483,151 @@ func (k rawJSONWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
 		return nil, fmt.Errorf("Invalid RSA key, missing n/e values")
 	}
 
+	if e := k.E.bigInt(); !e.IsInt64() || e.Int64() > math.MaxInt32 {
+		return nil, fmt.Errorf("Invalid RSA key, e value out of range")
+	}
+
 	return &rsa.PublicKey{
 		N: k.N.bigInt(),
 		E: k.E.toInt(),
 	}, nil
 }
+
+func (k rawJSONWebKey) rsaPrivateKey() (*rsa.PrivateKey, error) {
+	pub, err := k.rsaPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.D.bytes()) == 0 || len(k.P.bytes()) == 0 || len(k.Q.bytes()) == 0 {
+		return nil, fmt.Errorf("Invalid RSA private key, missing d/p/q values")
+	}
+
+	priv := &rsa.PrivateKey{
+		PublicKey: *pub,
+		D:         k.D.bigInt(),
+		Primes:    []*big.Int{k.P.bigInt(), k.Q.bigInt()},
+	}
+	if err = priv.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid RSA private key, %s", err)
+	}
+	priv.Precompute()
+
+	if (k.Dp != nil && priv.Precomputed.Dp.Cmp(k.Dp.bigInt()) != 0) ||
+		(k.Dq != nil && priv.Precomputed.Dq.Cmp(k.Dq.bigInt()) != 0) ||
+		(k.Qi != nil && priv.Precomputed.Qinv.Cmp(k.Qi.bigInt()) != 0) {
+		return nil, fmt.Errorf("Invalid RSA private key, dp/dq/qi values do not match")
+	}
+
+	return priv, nil
+}
+
+func (k rawJSONWebKey) ecPublicKey() (*ecdsa.PublicKey, error) {
+	curve, err := curveFromName(k.Crv)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.X == nil || k.Y == nil {
+		return nil, fmt.Errorf("Invalid EC key, missing x/y values")
+	}
+
+	size := curveSize(curve)
+	if len(k.X.bytes()) != size || len(k.Y.bytes()) != size {
+		return nil, fmt.Errorf("Invalid EC key, wrong length for x/y values on curve %s", k.Crv)
+	}
+
+	x, y := k.X.bigInt(), k.Y.bigInt()
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("Invalid EC key, point (x, y) is not on curve %s", k.Crv)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     x,
+		Y:     y,
+	}, nil
+}
+
+func (k rawJSONWebKey) ecPrivateKey() (*ecdsa.PrivateKey, error) {
+	pub, err := k.ecPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.D.bytes()) != curveSize(pub.Curve) {
+		return nil, fmt.Errorf("Invalid EC private key, wrong length for d value on curve %s", k.Crv)
+	}
+
+	d := k.D.bigInt()
+	if d.Sign() <= 0 || d.Cmp(pub.Curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("Invalid EC private key, d value out of range")
+	}
+	if x, y := pub.Curve.ScalarBaseMult(k.D.bytes()); x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+		return nil, fmt.Errorf("Invalid EC private key, d value does not match x/y values")
+	}
+
+	return &ecdsa.PrivateKey{
+		PublicKey: *pub,
+		D:         d,
+	}, nil
+}
+
+func (k rawJSONWebKey) okpPublicKey() (interface{}, error) {
+	if k.X == nil {
+		return nil, fmt.Errorf("Invalid OKP key, missing x value")
+	}
+
+	switch k.Crv {
+	case "Ed25519":
+		if len(k.X.bytes()) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid Ed25519 key, wrong length for x value")
+		}
+		return ed25519.PublicKey(k.X.bytes()), nil
+	case "X25519":
+		if len(k.X.bytes()) != x25519KeySize {
+			return nil, fmt.Errorf("Invalid X25519 key, wrong length for x value")
+		}
+		return ecdh.X25519().NewPublicKey(k.X.bytes())
+	default:
+		return nil, fmt.Errorf("Unsupported OKP curve '%s'", k.Crv)
+	}
+}
+
+func (k rawJSONWebKey) octKey() ([]byte, error) {
+	if len(k.K.bytes()) == 0 {
+		return nil, fmt.Errorf("Invalid oct key, missing k value")
+	}
+
+	return k.K.bytes(), nil
+}
+
+func (k rawJSONWebKey) okpPrivateKey() (interface{}, error) {
+	pub, err := k.okpPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p := pub.(type) {
+	case ed25519.PublicKey:
+		if len(k.D.bytes()) != ed25519.SeedSize {
+			return nil, fmt.Errorf("Invalid Ed25519 private key, wrong length for d value")
+		}
+		priv := ed25519.NewKeyFromSeed(k.D.bytes())
+		if !p.Equal(priv.Public()) {
+			return nil, fmt.Errorf("Invalid Ed25519 private key, d value does not match x value")
+		}
+		return priv, nil
+	case *ecdh.PublicKey:
+		priv, err := ecdh.X25519().NewPrivateKey(k.D.bytes())
+		if err != nil {
+			return nil, fmt.Errorf("Invalid X25519 private key, %s", err)
+		}
+		if !p.Equal(priv.PublicKey()) {
+			return nil, fmt.Errorf("Invalid X25519 private key, d value does not match x value")
+		}
+		return priv, nil
+	default:
+		return nil, fmt.Errorf("Unsupported OKP curve '%s'", k.Crv)
+	}
+}
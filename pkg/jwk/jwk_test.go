@@ -2,17 +2,43 @@ package jwk
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var rsaTestKey, _ = rsa.GenerateKey(rand.Reader, 2048)
 
+var (
+	ecTestKey256, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecTestKey384, _ = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ecTestKey521, _ = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+)
+
+var (
+	ed25519TestPublicKey, ed25519TestKey, _ = ed25519.GenerateKey(rand.Reader)
+	x25519TestKey, _                        = ecdh.X25519().GenerateKey(rand.Reader)
+)
+
+// RSA modulus of the certificate in testCertificatesStr
+const testCertificateModulus = "yeNlzlub94YgerT030codqEztjfU_S6X4DbDA_iVKkjAWtYfPHDzz_sPCT1Axz6isZdf3lHpq_gYX4Sz-cbe4rjmig" +
+	"xUxr-FgKHQy3HeCdK6hNq9ASQvMK9LBOpXDNn7mei6RZWom4wo3CMvvsY1w8tjtfLb-yQwJPltHxShZq5-ihC9irpLI9xEBTgG12q5lGIFPhTl" +
+	"_7inA1PFK97LuSLnTJzW0bj096v_TMDg7pOWm_zHtF53qbVsI0e3v5nmdKXdFf9BjIARRfVrbxVxiZHjU6zL6jY5QJdh1QCmENoejj_ytspMmGW" +
+	"7yMRxzUqgxcAqOBpVm0b-_mW3HoBdjQ"
+
 // Test X.509 certificates
 var testCertificates, _ = x509.ParseCertificates(fromBase64Bytes(`
 MIICUjCCAbugAwIBAgIBADANBgkqhkiG9w0BAQ0FADBGMQswCQYDVQQGEwJzZzES
@@ -56,7 +82,14 @@ func TestX5C(t *testing.T) {
 func TestMarshalUnmarshal(t *testing.T) {
 	kid, use := "ABCDEF", "sig"
 
-	for _, key := range []interface{}{&rsaTestKey.PublicKey} {
+	for _, key := range []interface{}{
+		&rsaTestKey.PublicKey,
+		&ecTestKey256.PublicKey,
+		&ecTestKey384.PublicKey,
+		&ecTestKey521.PublicKey,
+		ed25519TestPublicKey,
+		x25519TestKey.PublicKey(),
+	} {
 		jwk := JSONWebKey{Key: key, KeyID: kid, Algorithm: "RS256", Use: use}
 
 		jsonbar, err := jwk.MarshalJSON()
@@ -115,6 +148,20 @@ func TestWebKeyInvalid(t *testing.T) {
 		`{"kty":"RSA"}`,
 		`{"kty":"RSA","e":""}`,
 		`{"kty":"RSA","e":"XXXX"}`,
+		// Invalid EC keys
+		`{"kty":"EC","crv":"P-256"}`,
+		`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4"}`,
+		`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyQ"}`,
+		`{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`,
+		`{"kty":"EC","crv":"P-192","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"}`,
+		// Invalid OKP keys
+		`{"kty":"OKP","crv":"Ed25519"}`,
+		`{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcH"}`,
+		`{"kty":"OKP","crv":"X25519","x":"AQ"}`,
+		`{"kty":"OKP","crv":"Ed448","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+		// Invalid oct keys
+		`{"kty":"oct"}`,
+		`{"kty":"oct","k":""}`,
 	}
 
 	for _, key := range keys {
@@ -135,6 +182,16 @@ func TestJWKValid(t *testing.T) {
 		{&rsa.PublicKey{}, false},
 		{&rsaPub, true},
 		{&rsa.PrivateKey{}, false},
+		{&ecdsa.PublicKey{}, false},
+		{&ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)}, false},
+		{&ecTestKey256.PublicKey, true},
+		{&ecTestKey521.PublicKey, true},
+		{ed25519.PublicKey{}, false},
+		{ed25519TestPublicKey, true},
+		{x25519TestKey.PublicKey(), true},
+		{[]byte{}, false},
+		{make([]byte, 15), false},
+		{make([]byte, 16), true},
 	}
 
 	for _, tc := range cases {
@@ -144,3 +201,470 @@ func TestJWKValid(t *testing.T) {
 			fmt.Sprintf("expected Valid to return %t, got %t", tc.expectedValidity, valid))
 	}
 }
+
+func TestECThumbprint(t *testing.T) {
+	// EC key from RFC 7517 Appendix A.1
+	ecKey := `{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",` +
+		`"y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","use":"enc","kid":"1"}`
+
+	var jwk JSONWebKey
+	err := jwk.UnmarshalJSON([]byte(ecKey))
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+	assert(t, jwk.Valid(), "EC key should be valid")
+
+	tp, err := jwk.Thumbprint(crypto.SHA256)
+	assert(t, err == nil, fmt.Sprintf("problem computing thumbprint %s", err))
+	assert(t, base64.RawURLEncoding.EncodeToString(tp) == "cn-I_WNMClehiVp51i_0VpOENW1upEerA8sEam5hn-s",
+		fmt.Sprintf("unexpected thumbprint %s", base64.RawURLEncoding.EncodeToString(tp)))
+}
+
+func TestOKPThumbprint(t *testing.T) {
+	// Ed25519 key from RFC 8037 Appendix A.2
+	okpKey := `{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`
+
+	var jwk JSONWebKey
+	err := jwk.UnmarshalJSON([]byte(okpKey))
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+	assert(t, jwk.Valid(), "OKP key should be valid")
+
+	// Thumbprint from RFC 8037 Appendix A.3
+	tp, err := jwk.Thumbprint(crypto.SHA256)
+	assert(t, err == nil, fmt.Sprintf("problem computing thumbprint %s", err))
+	assert(t, base64.RawURLEncoding.EncodeToString(tp) == "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		fmt.Sprintf("unexpected thumbprint %s", base64.RawURLEncoding.EncodeToString(tp)))
+}
+
+func TestMarshalUnmarshalOKPJWKSet(t *testing.T) {
+	var set JSONWebKeySet
+	set.Keys = append(set.Keys, JSONWebKey{Key: ed25519TestPublicKey, KeyID: "ed", Algorithm: "EdDSA", Use: "sig"})
+	set.Keys = append(set.Keys, JSONWebKey{Key: x25519TestKey.PublicKey(), KeyID: "x", Algorithm: "ECDH-ES", Use: "enc"})
+
+	jsonbar, err := json.Marshal(&set)
+	assert(t, err == nil, fmt.Sprintf("problem marshalling set %s", err))
+
+	var set2 JSONWebKeySet
+	err = json.Unmarshal(jsonbar, &set2)
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling set %s", err))
+	assert(t, len(set2.Keys) == 2, fmt.Sprintf("it should return key set with two keys not %d", len(set2.Keys)))
+	assert(t, reflect.DeepEqual(set2.Keys[0].Key, ed25519TestPublicKey), "Ed25519 key not equal")
+	assert(t, x25519TestKey.PublicKey().Equal(set2.Keys[1].Key), "X25519 key not equal")
+
+	jsonbar2, err := json.Marshal(&set2)
+	assert(t, err == nil, fmt.Sprintf("problem marshalling set %s", err))
+	assert(t, bytes.Equal(jsonbar, jsonbar2), fmt.Sprintf("it should not lose info"))
+}
+
+func TestOctKey(t *testing.T) {
+	// Symmetric key from RFC 7517 Appendix A.3
+	octKey := `{"kty":"oct","alg":"HS256","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow"}`
+
+	var jwk JSONWebKey
+	err := jwk.UnmarshalJSON([]byte(octKey))
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+	assert(t, jwk.Valid(), "oct key should be valid")
+	assert(t, len(jwk.Key.([]byte)) == 64, "oct key should be 64 bytes")
+
+	jsonbar, err := jwk.MarshalJSON()
+	assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+	var jwk2 JSONWebKey
+	err = jwk2.UnmarshalJSON(jsonbar)
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+	assert(t, bytes.Equal(jwk.Key.([]byte), jwk2.Key.([]byte)), "it should not lose info")
+
+	_, err = jwk.Thumbprint(crypto.SHA256)
+	assert(t, err == nil, fmt.Sprintf("problem computing thumbprint %s", err))
+
+	short := JSONWebKey{Key: make([]byte, 16), Algorithm: "HS256"}
+	assert(t, !short.Valid(), "16 bytes oct key should not be valid for HS256")
+}
+
+func TestMarshalJWKSetWithOctKey(t *testing.T) {
+	var set JSONWebKeySet
+	set.Keys = append(set.Keys, JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "rsa", Algorithm: "RS256"})
+	set.Keys = append(set.Keys, JSONWebKey{Key: make([]byte, 32), KeyID: "oct", Algorithm: "HS256"})
+
+	_, err := json.Marshal(&set)
+	assert(t, err != nil, "it should refuse to marshal oct key in a public set")
+
+	jsonbar, err := set.MarshalSecretJSON()
+	assert(t, err == nil, fmt.Sprintf("problem marshalling set %s", err))
+
+	var set2 JSONWebKeySet
+	err = json.Unmarshal(jsonbar, &set2)
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling set %s", err))
+	assert(t, len(set2.Keys) == 2, fmt.Sprintf("it should return key set with two keys not %d", len(set2.Keys)))
+}
+
+func TestMarshalUnmarshalPrivateKey(t *testing.T) {
+	kid, use := "ABCDEF", "sig"
+
+	for _, key := range []interface{}{
+		rsaTestKey,
+		ecTestKey256,
+		ecTestKey384,
+		ecTestKey521,
+		ed25519TestKey,
+		x25519TestKey,
+	} {
+		jwk := JSONWebKey{Key: key, KeyID: kid, Algorithm: "alg", Use: use}
+		assert(t, jwk.Valid(), fmt.Sprintf("%T should be valid", key))
+		assert(t, !jwk.IsPublic(), fmt.Sprintf("%T should not be public", key))
+
+		jsonbar, err := jwk.MarshalJSON()
+		assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+
+		var jwk2 JSONWebKey
+		err = jwk2.UnmarshalJSON(jsonbar)
+		assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+		assert(t, reflect.TypeOf(jwk2.Key) == reflect.TypeOf(key), fmt.Sprintf("expected %T, got %T", key, jwk2.Key))
+
+		jsonbar2, err := jwk2.MarshalJSON()
+		assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+		assert(t, bytes.Equal(jsonbar, jsonbar2), fmt.Sprintf("it should not lose info"))
+
+		pub := jwk2.Public()
+		assert(t, pub.IsPublic(), fmt.Sprintf("%T public key should be public", key))
+		assert(t, pub.KeyID == kid && pub.Algorithm == "alg" && pub.Use == use, "kid/alg/use not match")
+
+		tp1, err := jwk2.Thumbprint(crypto.SHA256)
+		assert(t, err == nil, fmt.Sprintf("problem computing thumbprint %s", err))
+		tp2, err := pub.Thumbprint(crypto.SHA256)
+		assert(t, err == nil, fmt.Sprintf("problem computing thumbprint %s", err))
+		assert(t, bytes.Equal(tp1, tp2), "private and public thumbprints should match")
+	}
+
+	oct := JSONWebKey{Key: make([]byte, 32), KeyID: kid}
+	assert(t, oct.Public().Key == nil, "oct key should have no public key")
+
+	jwk := JSONWebKey{Key: rsaTestKey, KeyID: kid, KeyOps: []string{"sign", "verify"}}
+	pub := jwk.Public()
+	assert(t, reflect.DeepEqual(pub.KeyOps, []string{"verify"}), fmt.Sprintf("sign should map to verify not %v", pub.KeyOps))
+	assert(t, jwk.KeyOps[0] == "sign", "Public should not change the private key")
+	jwk.KeyOps = []string{"decrypt", "unwrapKey", "deriveKey"}
+	pub = jwk.Public()
+	assert(t, reflect.DeepEqual(pub.KeyOps, []string{"encrypt", "wrapKey"}), fmt.Sprintf("it should map private key_ops not %v", pub.KeyOps))
+	data, err := pub.MarshalJSON()
+	assert(t, err == nil && !strings.Contains(string(data), "decrypt"), fmt.Sprintf("public JWK should not advertise private key_ops %s", data))
+}
+
+func TestUnmarshalPrivateKey(t *testing.T) {
+	keys := map[string]string{
+		// EC private key from RFC 7517 Appendix A.2
+		"ec": `{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",` +
+			`"y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE"}`,
+		// Ed25519 private key from RFC 8037 Appendix A.1
+		"ed25519": `{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A",` +
+			`"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+	}
+
+	for name, key := range keys {
+		var jwk JSONWebKey
+		err := jwk.UnmarshalJSON([]byte(key))
+		assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s key %s", name, err))
+		assert(t, jwk.Valid(), fmt.Sprintf("%s key should be valid", name))
+		assert(t, !jwk.IsPublic(), fmt.Sprintf("%s key should not be public", name))
+	}
+
+	invalid := []string{
+		// d does not match x/y
+		`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",` +
+			`"y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAI"}`,
+		// d does not match x
+		`{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2I",` +
+			`"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+		// missing p/q
+		`{"kty":"RSA","n":"VKOoRQ","e":"AQAB","d":"AQAB"}`,
+	}
+
+	for _, key := range invalid {
+		var jwk JSONWebKey
+		err := jwk.UnmarshalJSON([]byte(key))
+		assert(t, err != nil, fmt.Sprintf("managed to parse invalid key %s", key))
+	}
+}
+
+func TestMarshalJWKSetWithPrivateKey(t *testing.T) {
+	var set JSONWebKeySet
+	set.Keys = append(set.Keys, JSONWebKey{Key: rsaTestKey, KeyID: "rsa", Algorithm: "RS256"})
+
+	_, err := json.Marshal(&set)
+	assert(t, err != nil, "it should refuse to marshal private key in a public set")
+
+	set.Keys[0] = set.Keys[0].Public()
+	_, err = json.Marshal(&set)
+	assert(t, err == nil, fmt.Sprintf("problem marshalling set %s", err))
+}
+
+func TestRoundTripProviderJWKS(t *testing.T) {
+	n := testCertificateModulus
+	sets := map[string]string{
+		"google": `{"keys":[{"e":"AQAB","kty":"RSA","alg":"RS256","n":"` + n +
+			`","use":"sig","kid":"0d8a67399e7882acae7d7f68b2280256a796a582"}]}`,
+		"microsoft": `{"keys":[{"kty":"RSA","use":"sig","kid":"Za9pCbGwdY4GxuBIxGACtcaV42s",` +
+			`"x5t":"Za9pCbGwdY4GxuBIxGACtcaV42s","n":"` + n + `","e":"AQAB","x5c":["` + testCertificatesStr + `"],` +
+			`"issuer":"https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0"}]}`,
+		"auth0": `{"keys":[{"alg":"RS256","kty":"RSA","use":"sig","n":"` + n + `","e":"AQAB",` +
+			`"kid":"NjVBRjY5MDlCMUIwNzU4RTA2QzZFMDQ4QzQ2MDAyQjVDNjk1RTM2Qg","x5t":"Za9pCbGwdY4GxuBIxGACtcaV42s",` +
+			`"x5c":["` + testCertificatesStr + `"]}]}`,
+		"spiffe": `{"keys":[{"use":"jwt-svid","kty":"EC","kid":"C6vs25welZOx6WksNYfbMfiw9l96pMnD",` +
+			`"crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM",` +
+			`"nbf":1700000000,"exp":1900000000}],"spiffe_sequence":12035488,"spiffe_refresh_hint":300}`,
+	}
+
+	for name, data := range sets {
+		var set JSONWebKeySet
+		err := json.Unmarshal([]byte(data), &set)
+		assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s set %s", name, err))
+
+		jsonbar, err := json.Marshal(&set)
+		assert(t, err == nil, fmt.Sprintf("problem marshalling %s set %s", name, err))
+		assert(t, string(jsonbar) == data, fmt.Sprintf("%s set should round trip, got %s", name, jsonbar))
+	}
+}
+
+func TestExtraMembers(t *testing.T) {
+	jwk := JSONWebKey{
+		Key:   &rsaTestKey.PublicKey,
+		KeyID: "ABCDEFG",
+		Extra: map[string]json.RawMessage{
+			"issuer": json.RawMessage(`"https://andy2046.io"`),
+			"exp":    json.RawMessage(` 1900000000 `),
+		},
+	}
+
+	jsonbar, err := jwk.MarshalJSON()
+	assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+
+	var jwk2 JSONWebKey
+	err = jwk2.UnmarshalJSON(jsonbar)
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+	assert(t, len(jwk2.Extra) == 2, fmt.Sprintf("it should keep two extra members not %d", len(jwk2.Extra)))
+	assert(t, string(jwk2.Extra["issuer"]) == `"https://andy2046.io"`, "issuer not match")
+	assert(t, string(jwk2.Extra["exp"]) == `1900000000`, "exp not match")
+
+	jwk.Extra["kty"] = json.RawMessage(`"EC"`)
+	_, err = jwk.MarshalJSON()
+	assert(t, err != nil, "it should refuse extra member conflicting with a registered one")
+}
+
+func TestCertificateParameters(t *testing.T) {
+	n := testCertificateModulus
+	key := `{"kty":"RSA","use":"sig","key_ops":["verify"],"n":"` + n + `","e":"AQAB",` +
+		`"x5u":"https://andy2046.io/certs.pem","x5c":["` + testCertificatesStr + `"],` +
+		`"x5t":"Za9pCbGwdY4GxuBIxGACtcaV42s","x5t#S256":"wAKoXV8WQUdFgeLqEbcpqL2UL0IEJ-UrZ0RyNSzo1vM"}`
+
+	var jwk JSONWebKey
+	err := jwk.UnmarshalJSON([]byte(key))
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+	assert(t, jwk.Valid(), "key should be valid")
+	assert(t, reflect.DeepEqual(jwk.KeyOps, []string{"verify"}), "key_ops not match")
+	assert(t, jwk.CertificatesURL.String() == "https://andy2046.io/certs.pem", "x5u not match")
+	assert(t, len(jwk.CertificateThumbprintSHA1) == 20, "x5t not match")
+	assert(t, len(jwk.CertificateThumbprintSHA256) == 32, "x5t#S256 not match")
+
+	jsonbar, err := jwk.MarshalJSON()
+	assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+	assert(t, string(jsonbar) == key, fmt.Sprintf("it should not lose info, got %s", jsonbar))
+
+	invalid := []string{
+		// x5t does not match x5c
+		`{"kty":"RSA","n":"` + n + `","e":"AQAB","x5c":["` + testCertificatesStr + `"],` +
+			`"x5t":"Za9pCbGwdY4GxuBIxGACtcaV42I"}`,
+		// x5t#S256 does not match x5c
+		`{"kty":"RSA","n":"` + n + `","e":"AQAB","x5c":["` + testCertificatesStr + `"],` +
+			`"x5t#S256":"wAKoXV8WQUdFgeLqEbcpqL2UL0IEJ-UrZ0RyNSzo1vI"}`,
+		// x5t wrong length
+		`{"kty":"RSA","n":"` + n + `","e":"AQAB","x5t":"Za9pCbGwdY4G"}`,
+		// use and key_ops contradict
+		`{"kty":"RSA","n":"` + n + `","e":"AQAB","use":"sig","key_ops":["encrypt"]}`,
+		// duplicate key_ops
+		`{"kty":"RSA","n":"` + n + `","e":"AQAB","key_ops":["verify","verify"]}`,
+		// invalid x5u
+		`{"kty":"RSA","n":"` + n + `","e":"AQAB","x5u":":andy2046.io"}`,
+	}
+
+	for _, key := range invalid {
+		var jwk2 JSONWebKey
+		err := jwk2.UnmarshalJSON([]byte(key))
+		assert(t, err != nil, fmt.Sprintf("managed to parse invalid key %s", key))
+	}
+
+	jwk.Use = "enc"
+	assert(t, !jwk.Valid(), "key with contradicting use and key_ops should not be valid")
+	_, err = jwk.MarshalJSON()
+	assert(t, err != nil, "it should refuse to marshal key with contradicting use and key_ops")
+}
+
+func TestParseKeySetLenient(t *testing.T) {
+	data := `{"keys":[` +
+		`{"kty":"RSA","kid":"rsa","n":"` + testCertificateModulus + `","e":"AQAB"},` +
+		`{"kty":"Ed448","kid":"unknown","x":"AQAB"},` +
+		`{"kty":"EC","kid":"malformed","crv":"P-256","x":"AQ","y":"AQ"},` +
+		`{"kty":"OKP","crv":"Ed25519","kid":"ed","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}` +
+		`],"spiffe_sequence":1}`
+
+	var strict JSONWebKeySet
+	err := json.Unmarshal([]byte(data), &strict)
+	assert(t, err != nil, "strict decoding should fail on unsupported keys")
+
+	set, warnings, err := ParseKeySetLenient([]byte(data))
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling set %s", err))
+	assert(t, len(set.Keys) == 2, fmt.Sprintf("it should return key set with two keys not %d", len(set.Keys)))
+	assert(t, set.Keys[0].KeyID == "rsa" && set.Keys[1].KeyID == "ed", "it should keep valid keys in order")
+	assert(t, len(set.Extra) == 1, "it should keep extra members")
+	assert(t, len(warnings) == 2, fmt.Sprintf("it should return two warnings not %d", len(warnings)))
+	assert(t, warnings[0].Index == 1 && warnings[0].KeyID == "unknown", "first warning not match")
+	assert(t, warnings[1].Index == 2 && warnings[1].KeyID == "malformed", "second warning not match")
+
+	_, _, err = ParseKeySetLenient([]byte(`{"keys":{}}`))
+	assert(t, err != nil, "it should fail on invalid key set")
+}
+
+// Create a certificate for pub signed by parent, self-signed if parent is nil, for testing.
+func createTestCertificate(t *testing.T, pub, signer interface{}, parent *x509.Certificate, isCA bool) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyCertificates(t *testing.T) {
+	root := createTestCertificate(t, &ecTestKey384.PublicKey, ecTestKey384, nil, true)
+	intermediate := createTestCertificate(t, &ecTestKey521.PublicKey, ecTestKey384, root, true)
+	leaf := createTestCertificate(t, &ecTestKey256.PublicKey, ecTestKey521, intermediate, false)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+
+	jwk := JSONWebKey{Key: &ecTestKey256.PublicKey, Certificates: []*x509.Certificate{leaf, intermediate}}
+	chains, err := jwk.VerifyCertificates(opts)
+	assert(t, err == nil, fmt.Sprintf("problem verifying certificates %s", err))
+	assert(t, len(chains) == 1 && len(chains[0]) == 3, "it should return one chain of three certificates")
+
+	jwk.Key = ecTestKey256
+	_, err = jwk.VerifyCertificates(opts)
+	assert(t, err == nil, fmt.Sprintf("problem verifying certificates of private key %s", err))
+
+	jwk.Key = &ecTestKey384.PublicKey
+	_, err = jwk.VerifyCertificates(opts)
+	assert(t, err == ErrCertificateKeyMismatch, fmt.Sprintf("it should report key mismatch not %s", err))
+
+	jwk = JSONWebKey{Key: &ecTestKey256.PublicKey, Certificates: []*x509.Certificate{leaf}}
+	_, err = jwk.VerifyCertificates(opts)
+	assert(t, err != nil, "it should fail without intermediate certificate")
+
+	jwk = JSONWebKey{Key: &ecTestKey256.PublicKey}
+	_, err = jwk.VerifyCertificates(opts)
+	assert(t, err != nil, "it should fail without certificates")
+
+	jwk = JSONWebKey{Key: &ecTestKey256.PublicKey, Certificates: []*x509.Certificate{leaf, intermediate}}
+	opts.CurrentTime = time.Now().Add(2 * time.Hour)
+	_, err = jwk.VerifyCertificates(opts)
+	assert(t, err != nil, "it should fail with expired certificates")
+}
+
+func TestGenerate(t *testing.T) {
+	cases := []struct {
+		alg  string
+		bits int
+		key  interface{}
+	}{
+		{"RS256", 0, &rsa.PrivateKey{}},
+		{"PS384", 3072, &rsa.PrivateKey{}},
+		{"ES256", 0, &ecdsa.PrivateKey{}},
+		{"ES384", 0, &ecdsa.PrivateKey{}},
+		{"ES512", 0, &ecdsa.PrivateKey{}},
+		{"EdDSA", 0, ed25519.PrivateKey{}},
+		{"HS256", 0, []byte{}},
+		{"HS512", 1024, []byte{}},
+	}
+
+	for _, tc := range cases {
+		jwk, err := Generate(tc.alg, tc.bits)
+		assert(t, err == nil, fmt.Sprintf("problem generating %s key %s", tc.alg, err))
+		assert(t, reflect.TypeOf(jwk.Key) == reflect.TypeOf(tc.key), fmt.Sprintf("expected %T, got %T", tc.key, jwk.Key))
+		assert(t, jwk.Valid(), fmt.Sprintf("%s key should be valid", tc.alg))
+		assert(t, jwk.Algorithm == tc.alg && jwk.Use == "sig", "alg/use not match")
+
+		tp, _ := thumbprintKeyID(jwk)
+		assert(t, jwk.KeyID == tp, "kid should be the key thumbprint")
+
+		switch k := jwk.Key.(type) {
+		case *rsa.PrivateKey:
+			expected := tc.bits
+			if expected == 0 {
+				expected = 2048
+			}
+			assert(t, k.N.BitLen() == expected, fmt.Sprintf("expected %d bits RSA key, got %d", expected, k.N.BitLen()))
+		case []byte:
+			expected := tc.bits / 8
+			if expected == 0 {
+				expected = 32
+			}
+			assert(t, len(k) == expected, fmt.Sprintf("expected %d bytes oct key, got %d", expected, len(k)))
+		}
+	}
+
+	_, err := Generate("none", 0)
+	assert(t, err != nil, "it should fail on unsupported algorithm")
+	_, err = Generate("HS256", 128)
+	assert(t, err != nil, "it should fail on too short HMAC key")
+}
+
+func TestCloneKeyMaterial(t *testing.T) {
+	for _, key := range []interface{}{
+		&rsaTestKey.PublicKey,
+		rsaTestKey,
+		&ecTestKey256.PublicKey,
+		ecTestKey256,
+		ed25519TestPublicKey,
+		ed25519TestKey,
+		x25519TestKey.PublicKey(),
+		x25519TestKey,
+		[]byte("0123456789abcdef0123456789abcdef"),
+	} {
+		jwk := JSONWebKey{Key: key, KeyID: "ABCDEFG", Certificates: testCertificates}
+		clone := jwk.clone()
+		assert(t, reflect.DeepEqual(clone.Key, key), fmt.Sprintf("%T clone should equal the key", key))
+		assert(t, clone.Certificates[0] != testCertificates[0] && clone.Certificates[0].Equal(testCertificates[0]), "certificates should be copied")
+
+		switch k := clone.Key.(type) {
+		case *rsa.PublicKey:
+			k.N.SetInt64(1)
+		case *rsa.PrivateKey:
+			k.D.SetInt64(1)
+			k.Primes[0].SetInt64(1)
+		case *ecdsa.PublicKey:
+			k.X.SetInt64(1)
+		case *ecdsa.PrivateKey:
+			k.D.SetInt64(1)
+		case ed25519.PublicKey:
+			k[0]++
+		case ed25519.PrivateKey:
+			k[0]++
+		case []byte:
+			k[0]++
+		default:
+			assert(t, clone.Key != key, fmt.Sprintf("%T should be copied", key))
+			continue
+		}
+		assert(t, !reflect.DeepEqual(clone.Key, key), fmt.Sprintf("%T key material should be copied", key))
+	}
+}
@@ -0,0 +1,62 @@
+package jwk
+
+// Listener receives Client notifications, nil handlers are ignored.
+// Handlers run synchronously on the refreshing goroutine after the
+// cached key set is updated, they should not block, but may call
+// Refresh or GetKey.
+type Listener struct {
+	// OnRefresh is called after each successful refresh with copies
+	// of the previous and current key sets, and their diff, which is
+	// empty if the server replies Not Modified.
+	OnRefresh func(old, current *JSONWebKeySet, diff *KeySetDiff)
+	// OnError is called after each failed refresh.
+	OnError func(err error)
+}
+
+// Subscribe registers listener for Client notifications,
+// it returns a function to unregister listener.
+func (client *Client) Subscribe(listener Listener) (unsubscribe func()) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.listeners == nil {
+		client.listeners = make(map[int]Listener)
+	}
+	id := client.listenerID
+	client.listenerID++
+	client.listeners[id] = listener
+
+	return func() {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		delete(client.listeners, id)
+	}
+}
+
+func (client *Client) notifyRefresh(old, current *keySnapshot) {
+	for _, listener := range client.listenersCopy() {
+		if listener.OnRefresh != nil {
+			oldSet, newSet := old.keySet(), current.keySet()
+			listener.OnRefresh(oldSet, newSet, oldSet.Diff(newSet))
+		}
+	}
+}
+
+func (client *Client) notifyError(err error) {
+	for _, listener := range client.listenersCopy() {
+		if listener.OnError != nil {
+			listener.OnError(err)
+		}
+	}
+}
+
+func (client *Client) listenersCopy() []Listener {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+
+	listeners := make([]Listener, 0, len(client.listeners))
+	for _, listener := range client.listeners {
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
@@ -0,0 +1,115 @@
+package jwk
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// unknownKeys rate limits refreshes on lookups of unknown key IDs.
+type unknownKeys struct {
+	last     time.Time            // start of the last on-demand refresh
+	inflight *refreshCall         // on-demand refresh in progress
+	expiry   map[string]time.Time // negative cache of unknown key IDs
+}
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// GetKey returns the cached key by key ID, refreshing the cache if it is
+// not found, at most once per UnknownKeyRefreshInterval, key IDs still not
+// found after refresh are remembered for UnknownKeyCacheTimeout.
+// It returns ErrKeyNotFound if the key is absent, or the refresh error,
+// or ErrStaleKeySet if keys are older than MaxStaleness.
+func (client *Client) GetKey(ctx context.Context, kid string) (*JSONWebKey, error) {
+	if err := client.checkStale(); err != nil {
+		return nil, err
+	}
+	if key := client.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	if !client.isStarted() || client.isClosed() {
+		return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+	}
+
+	call := client.refreshUnknown(kid)
+	if call == nil {
+		return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+	}
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if key := client.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	client.markUnknown(kid)
+	return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+}
+
+func (client *Client) cachedKey(kid string) *JSONWebKey {
+	keys := client.loadSnapshot().key(kid)
+	if len(keys) == 0 {
+		return nil
+	}
+	return &keys[0]
+}
+
+// refreshUnknown returns the on-demand refresh to wait for,
+// or nil if kid is known to be unknown or refresh is rate limited.
+func (client *Client) refreshUnknown(kid string) *refreshCall {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	u := &client.unknownKeys
+	now := time.Now()
+	if expiry, ok := u.expiry[kid]; ok && now.Before(expiry) {
+		return nil
+	}
+	if u.inflight != nil {
+		return u.inflight
+	}
+	if now.Sub(u.last) < client.config.UnknownKeyRefreshInterval {
+		return nil
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	u.last, u.inflight = now, call
+	go func() {
+		_, call.err = client.fetchJWKS(client.ctx, false)
+		client.mutex.Lock()
+		u.inflight = nil
+		client.mutex.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+func (client *Client) markUnknown(kid string) {
+	timeout := client.config.UnknownKeyCacheTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	u := &client.unknownKeys
+	now := time.Now()
+	if u.expiry == nil {
+		u.expiry = make(map[string]time.Time)
+	}
+	for k, expiry := range u.expiry {
+		if !now.Before(expiry) {
+			delete(u.expiry, k)
+		}
+	}
+	u.expiry[kid] = now.Add(timeout)
+}
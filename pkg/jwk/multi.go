@@ -0,0 +1,287 @@
+package jwk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// CollisionPolicy decides how MultiClient merges keys
+// with the same key ID from different members.
+type CollisionPolicy int
+
+const (
+	// CollisionFirstWins keeps the keys of the first member holding the key ID.
+	CollisionFirstWins CollisionPolicy = iota
+	// CollisionKeepAll keeps the keys of every member.
+	CollisionKeepAll
+	// CollisionReject drops the keys whose key ID is held by several members.
+	CollisionReject
+)
+
+type (
+	// Member is a named Client of a MultiClient,
+	// it refreshes on its own schedule.
+	Member struct {
+		Name   string
+		Client *Client
+	}
+
+	// MultiClient merges the key sets of several clients into one.
+	MultiClient struct {
+		members  []Member
+		policy   CollisionPolicy
+		snapshot atomic.Value // *mergedSnapshot
+		mutex    sync.Mutex
+	}
+
+	// MultiClientStatus reports the health of a MultiClient.
+	MultiClientStatus struct {
+		// Members is the status of each member by name.
+		Members map[string]ClientStatus
+		// Collisions lists key IDs held by several members.
+		Collisions []string
+	}
+
+	mergedSnapshot struct {
+		*keySnapshot
+		collisions []string
+		// stale is whether each member was left out as stale,
+		// staleErr the error of the first one.
+		stale    []bool
+		staleErr error
+	}
+)
+
+// NewMultiClient returns a new MultiClient merging members in order,
+// it owns members and starts and stops them.
+func NewMultiClient(policy CollisionPolicy, members ...Member) (*MultiClient, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("No member")
+	}
+	names := make(map[string]bool, len(members))
+	for _, member := range members {
+		if member.Client == nil {
+			return nil, fmt.Errorf("Member '%s' has no Client", member.Name)
+		}
+		if member.Name == "" || names[member.Name] {
+			return nil, fmt.Errorf("Invalid member name '%s'", member.Name)
+		}
+		names[member.Name] = true
+	}
+
+	m := &MultiClient{
+		members: append([]Member(nil), members...),
+		policy:  policy,
+	}
+	for _, member := range m.members {
+		member.Client.Subscribe(Listener{
+			OnRefresh: func(old, current *JSONWebKeySet, diff *KeySetDiff) {
+				m.rebuild()
+			},
+		})
+	}
+	m.rebuild()
+	return m, nil
+}
+
+// Start starts every member.
+func (m *MultiClient) Start() error {
+	return m.StartContext(context.Background())
+}
+
+// StartContext starts every member, ctx bounds their initial fetch.
+// If a member fails to start, started ones are stopped.
+func (m *MultiClient) StartContext(ctx context.Context) error {
+	for i, member := range m.members {
+		if err := member.Client.StartContext(ctx); err != nil {
+			for _, started := range m.members[:i+1] {
+				started.Client.Stop()
+			}
+			return fmt.Errorf("Member '%s': %w", member.Name, err)
+		}
+	}
+	return nil
+}
+
+// Stop stops every member.
+func (m *MultiClient) Stop() {
+	for _, member := range m.members {
+		member.Client.Stop()
+	}
+}
+
+// Close closes every member, see Client Close.
+func (m *MultiClient) Close(ctx context.Context) error {
+	var first error
+	for _, member := range m.members {
+		if err := member.Client.Close(ctx); err != nil && first == nil {
+			first = fmt.Errorf("Member '%s': %w", member.Name, err)
+		}
+	}
+	return first
+}
+
+// KeySet returns a copy of the merged JSONWebKeySet, without keys
+// of members older than their MaxStaleness.
+func (m *MultiClient) KeySet() *JSONWebKeySet {
+	return m.current().keySet()
+}
+
+// FreshKeySet returns KeySet, or the ErrStaleKeySet
+// of the first member older than its MaxStaleness.
+func (m *MultiClient) FreshKeySet() (*JSONWebKeySet, error) {
+	snapshot := m.current()
+	if snapshot.staleErr != nil {
+		return nil, snapshot.staleErr
+	}
+	return snapshot.keySet(), nil
+}
+
+// Key returns merged keys by key ID, or ErrKeyNotFound.
+func (m *MultiClient) Key(kid string) ([]JSONWebKey, error) {
+	keys := m.current().key(kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+	}
+	return keys, nil
+}
+
+// GetKey returns the merged key by key ID, if it is not found
+// members look it up in order with their GetKey, refreshing on their
+// own terms, until it is merged. If it is still not found, it returns
+// the first member error other than ErrKeyNotFound, if any.
+func (m *MultiClient) GetKey(ctx context.Context, kid string) (*JSONWebKey, error) {
+	if keys := m.current().key(kid); len(keys) != 0 {
+		return &keys[0], nil
+	}
+
+	var memberErr error
+	for _, member := range m.members {
+		_, err := member.Client.GetKey(ctx, kid)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil && !errors.Is(err, ErrKeyNotFound) && memberErr == nil {
+			memberErr = fmt.Errorf("Member '%s': %w", member.Name, err)
+		}
+		// members rebuild the merged key set on refresh
+		if keys := m.current().key(kid); len(keys) != 0 {
+			return &keys[0], nil
+		}
+	}
+	if memberErr != nil {
+		return nil, memberErr
+	}
+	return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+}
+
+// Status returns the status of every member and key ID collisions.
+func (m *MultiClient) Status() MultiClientStatus {
+	status := MultiClientStatus{
+		Members:    make(map[string]ClientStatus, len(m.members)),
+		Collisions: append([]string(nil), m.current().collisions...),
+	}
+	for _, member := range m.members {
+		status.Members[member.Name] = member.Client.Status()
+	}
+	return status
+}
+
+// current returns the merged snapshot, merged again
+// if a member became stale since.
+func (m *MultiClient) current() *mergedSnapshot {
+	snapshot := m.snapshot.Load().(*mergedSnapshot)
+	if !m.staleChanged(snapshot) {
+		return snapshot
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	snapshot = m.snapshot.Load().(*mergedSnapshot)
+	if m.staleChanged(snapshot) {
+		snapshot = m.merge()
+		m.snapshot.Store(snapshot)
+	}
+	return snapshot
+}
+
+// staleChanged reports whether the staleness of a member
+// differs from the one snapshot was merged with.
+func (m *MultiClient) staleChanged(snapshot *mergedSnapshot) bool {
+	for i, member := range m.members {
+		if (member.Client.checkStale() != nil) != snapshot.stale[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MultiClient) rebuild() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.snapshot.Store(m.merge())
+}
+
+// merge merges member key sets according to the collision policy.
+func (m *MultiClient) merge() *mergedSnapshot {
+	sets := make([]*JSONWebKeySet, 0, len(m.members))
+	owners := make(map[string]int)
+	stale := make([]bool, len(m.members))
+	var staleErr error
+	for i, member := range m.members {
+		set, err := member.Client.FreshKeySet()
+		if err != nil {
+			stale[i] = true
+			if staleErr == nil {
+				staleErr = fmt.Errorf("Member '%s': %w", member.Name, err)
+			}
+			continue
+		}
+		sets = append(sets, set)
+		seen := make(map[string]bool)
+		for _, key := range set.Keys {
+			if key.KeyID != "" && !seen[key.KeyID] {
+				seen[key.KeyID] = true
+				owners[key.KeyID]++
+			}
+		}
+	}
+
+	var collisions []string
+	for kid, n := range owners {
+		if n > 1 {
+			collisions = append(collisions, kid)
+		}
+	}
+	sort.Strings(collisions)
+
+	merged := JSONWebKeySet{}
+	taken := make(map[string]bool)
+	for _, set := range sets {
+		own := make(map[string]bool)
+		for _, key := range set.Keys {
+			if key.KeyID != "" {
+				switch m.policy {
+				case CollisionFirstWins:
+					if taken[key.KeyID] && !own[key.KeyID] {
+						continue
+					}
+				case CollisionReject:
+					if owners[key.KeyID] > 1 {
+						continue
+					}
+				}
+				own[key.KeyID] = true
+			}
+			merged.Keys = append(merged.Keys, key)
+		}
+		for kid := range own {
+			taken[kid] = true
+		}
+	}
+	return &mergedSnapshot{newKeySnapshot(&merged), collisions, stale, staleErr}
+}
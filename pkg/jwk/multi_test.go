@@ -0,0 +1,121 @@
+package jwk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMember(t *testing.T, name string, keys ...JSONWebKey) (Member, *MemorySource) {
+	source, err := NewMemorySource(&JSONWebKeySet{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientWithSource(source, func(config *ClientConfig) error {
+		config.UnknownKeyRefreshInterval = 0
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Member{Name: name, Client: client}, source
+}
+
+func TestMultiClientCollisions(t *testing.T) {
+	tests := []struct {
+		policy CollisionPolicy
+		keys   int
+		shared int
+	}{
+		{CollisionFirstWins, 3, 1},
+		{CollisionKeepAll, 4, 2},
+		{CollisionReject, 2, 0},
+	}
+
+	for _, test := range tests {
+		a, _ := newTestMember(t, "a", JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "shared"}, JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "a"})
+		b, _ := newTestMember(t, "b", JSONWebKey{Key: &ecTestKey256.PublicKey, KeyID: "shared"}, JSONWebKey{Key: &ecTestKey256.PublicKey, KeyID: "b"})
+		multi, err := NewMultiClient(test.policy, a, b)
+		assert(t, err == nil, fmt.Sprintf("fail to NewMultiClient %s", err))
+		err = multi.Start()
+		assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+
+		keySet, err := multi.FreshKeySet()
+		assert(t, err == nil, fmt.Sprintf("fail to get FreshKeySet %s", err))
+		assert(t, len(keySet.Keys) == test.keys, fmt.Sprintf("policy %d: it should merge %d keys not %d", test.policy, test.keys, len(keySet.Keys)))
+		keys, _ := multi.Key("shared")
+		assert(t, len(keys) == test.shared, fmt.Sprintf("policy %d: it should return %d shared keys not %d", test.policy, test.shared, len(keys)))
+		if test.policy == CollisionFirstWins {
+			assert(t, keys[0].KeyType() == "RSA", "first member should win")
+		}
+		status := multi.Status()
+		assert(t, len(status.Collisions) == 1 && status.Collisions[0] == "shared", "it should report collisions")
+		multi.Stop()
+	}
+}
+
+func TestMultiClient(t *testing.T) {
+	a, sourceA := newTestMember(t, "a", JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "a"})
+	b, source := newTestMember(t, "b", JSONWebKey{Key: &ecTestKey256.PublicKey, KeyID: "b"})
+	multi, _ := NewMultiClient(CollisionFirstWins, a, b)
+	err := multi.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer multi.Stop()
+
+	// member refresh rebuilds merged key set
+	source.Set(&JSONWebKeySet{Keys: []JSONWebKey{{Key: &ecTestKey256.PublicKey, KeyID: "b"}, {Key: &ecTestKey384.PublicKey, KeyID: "c"}}})
+	b.Client.ForceRefresh()
+	_, err = multi.Key("c")
+	assert(t, err == nil, fmt.Sprintf("it should merge refreshed member %s", err))
+
+	// unknown key ID refreshes members
+	source.Set(&JSONWebKeySet{Keys: []JSONWebKey{{Key: &ecTestKey256.PublicKey, KeyID: "b"}, {Key: &ecTestKey521.PublicKey, KeyID: "d"}}})
+	_, err = multi.Key("d")
+	assert(t, errors.Is(err, ErrKeyNotFound), "Key should not refresh")
+	key, err := multi.GetKey(context.Background(), "d")
+	assert(t, err == nil && key.KeyID == "d", fmt.Sprintf("GetKey should refresh members %s", err))
+	_, err = multi.GetKey(context.Background(), "unknown")
+	assert(t, errors.Is(err, ErrKeyNotFound), fmt.Sprintf("it should return ErrKeyNotFound not %s", err))
+
+	// GetKey stops at the member resolving the key ID
+	lastSuccess := b.Client.Status().LastSuccess
+	sourceA.Set(&JSONWebKeySet{Keys: []JSONWebKey{{Key: &rsaTestKey.PublicKey, KeyID: "a"}, {Key: ed25519TestPublicKey, KeyID: "e"}}})
+	key, err = multi.GetKey(context.Background(), "e")
+	assert(t, err == nil && key.KeyID == "e", fmt.Sprintf("GetKey should refresh first member %s", err))
+	assert(t, b.Client.Status().LastSuccess.Equal(lastSuccess), "GetKey should not refresh members after the key is found")
+
+	status := multi.Status()
+	assert(t, len(status.Members) == 2 && status.Members["a"].KeyCount == 2 && status.Members["b"].KeyCount == 2, "it should report member status")
+
+	// stale member keys are left out
+	b.Client.config.MaxStaleness = time.Hour
+	b.Client.fetchedAt.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	_, err = multi.FreshKeySet()
+	assert(t, errors.Is(err, ErrStaleKeySet) && strings.Contains(err.Error(), "'b'"), fmt.Sprintf("FreshKeySet should report stale member not %s", err))
+	assert(t, len(multi.KeySet().Keys) == 2, "it should leave out stale member keys")
+	assert(t, multi.current() == multi.current(), "it should merge once while staleness is unchanged")
+	assert(t, multi.Status().Members["b"].Stale, "it should report stale member")
+	_, err = multi.GetKey(context.Background(), "unknown")
+	assert(t, errors.Is(err, ErrStaleKeySet), fmt.Sprintf("GetKey should report member error not %s", err))
+}
+
+func TestMultiClientMembers(t *testing.T) {
+	a, _ := newTestMember(t, "a", JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "a"})
+	_, err := NewMultiClient(CollisionFirstWins)
+	assert(t, err != nil, "NewMultiClient should fail without member")
+	_, err = NewMultiClient(CollisionFirstWins, a, a)
+	assert(t, err != nil, "NewMultiClient should fail on duplicate name")
+	_, err = NewMultiClient(CollisionFirstWins, Member{Name: "b"})
+	assert(t, err != nil, "NewMultiClient should fail on nil Client")
+
+	failing, _ := NewClientWithSource(SourceFunc(func(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+		return nil, errors.New("unavailable")
+	}))
+	multi, _ := NewMultiClient(CollisionFirstWins, a, Member{Name: "failing", Client: failing})
+	err = multi.Start()
+	assert(t, err != nil && strings.Contains(err.Error(), "failing"), fmt.Sprintf("Start should report failing member not %s", err))
+	assert(t, a.Client.isClosed(), "Start should stop started members")
+}
@@ -0,0 +1,214 @@
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+)
+
+// KeyEncoding is the DER encoding of a key.
+type KeyEncoding int
+
+const (
+	// PKCS1 encodes RSA public and private keys.
+	PKCS1 KeyEncoding = iota + 1
+	// PKCS8 encodes private keys.
+	PKCS8
+	// SEC1 encodes EC private keys.
+	SEC1
+	// PKIX encodes public keys, the public part of private keys is used.
+	PKIX
+)
+
+const (
+	pemCertificate   = "CERTIFICATE"
+	pemPublicKey     = "PUBLIC KEY"
+	pemPrivateKey    = "PRIVATE KEY"
+	pemRsaPublicKey  = "RSA PUBLIC KEY"
+	pemRsaPrivateKey = "RSA PRIVATE KEY"
+	pemEcPrivateKey  = "EC PRIVATE KEY"
+)
+
+// FromPEM returns the key from the first PEM block in data.
+// Consecutive CERTIFICATE blocks are read as a certificate chain, leaf first.
+func FromPEM(data []byte) (*JSONWebKey, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Fail to decode PEM data")
+	}
+
+	switch block.Type {
+	case pemCertificate:
+		var certs []*x509.Certificate
+		for block != nil && block.Type == pemCertificate {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+			block, rest = pem.Decode(rest)
+		}
+		return FromCertificate(certs...)
+	case pemPublicKey:
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(k)
+	case pemRsaPublicKey:
+		k, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(k)
+	case pemPrivateKey:
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(k)
+	case pemRsaPrivateKey:
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(k)
+	case pemEcPrivateKey:
+		k, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(k)
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block type '%s'", block.Type)
+	}
+}
+
+// FromDER returns the key from DER data, trying PKIX, PKCS#1, PKCS#8
+// and SEC1 encodings, then X.509 certificates.
+func FromDER(der []byte) (*JSONWebKey, error) {
+	if k, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return newKey(k)
+	}
+	if k, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return newKey(k)
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return newKey(k)
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return newKey(k)
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return newKey(k)
+	}
+	if certs, err := x509.ParseCertificates(der); err == nil && len(certs) > 0 {
+		return FromCertificate(certs...)
+	}
+	return nil, fmt.Errorf("Fail to parse DER data, unknown key encoding")
+}
+
+// FromCertificate returns the public key of the given certificate chain,
+// leaf first, with x5c and x5t#S256 filled in.
+func FromCertificate(certs ...*x509.Certificate) (*JSONWebKey, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("Missing certificate")
+	}
+
+	key, err := newKey(certs[0].PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(certs[0].Raw)
+	key.Certificates = certs
+	key.CertificateThumbprintSHA256 = sum[:]
+	return key, nil
+}
+
+// ToDER returns the given key in DER form using encoding.
+func (key *JSONWebKey) ToDER(encoding KeyEncoding) ([]byte, error) {
+	switch encoding {
+	case PKCS1:
+		switch k := key.Key.(type) {
+		case *rsa.PublicKey:
+			return x509.MarshalPKCS1PublicKey(k), nil
+		case *rsa.PrivateKey:
+			return x509.MarshalPKCS1PrivateKey(k), nil
+		}
+	case PKCS8:
+		if key.Key != nil && !key.IsPublic() {
+			if _, ok := key.Key.([]byte); !ok {
+				return x509.MarshalPKCS8PrivateKey(key.Key)
+			}
+		}
+	case SEC1:
+		if k, ok := key.Key.(*ecdsa.PrivateKey); ok {
+			return x509.MarshalECPrivateKey(k)
+		}
+	case PKIX:
+		if pub := key.Public().Key; pub != nil {
+			return x509.MarshalPKIXPublicKey(pub)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown key encoding %d", encoding)
+	}
+
+	return nil, fmt.Errorf("Unsupported key type '%s' for encoding %s", reflect.TypeOf(key.Key), encoding)
+}
+
+// ToPEM returns the given key in PEM form using encoding.
+func (key *JSONWebKey) ToPEM(encoding KeyEncoding) ([]byte, error) {
+	der, err := key.ToDER(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	var blockType string
+	switch encoding {
+	case PKCS1:
+		blockType = pemRsaPublicKey
+		if !key.IsPublic() {
+			blockType = pemRsaPrivateKey
+		}
+	case PKCS8:
+		blockType = pemPrivateKey
+	case SEC1:
+		blockType = pemEcPrivateKey
+	case PKIX:
+		blockType = pemPublicKey
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+}
+
+// String returns the encoding name.
+func (e KeyEncoding) String() string {
+	switch e {
+	case PKCS1:
+		return "PKCS1"
+	case PKCS8:
+		return "PKCS8"
+	case SEC1:
+		return "SEC1"
+	case PKIX:
+		return "PKIX"
+	default:
+		return fmt.Sprintf("KeyEncoding(%d)", int(e))
+	}
+}
+
+// newKey returns a JSONWebKey for k with a thumbprint key ID.
+func newKey(k interface{}) (*JSONWebKey, error) {
+	key := &JSONWebKey{Key: k}
+	kid, err := thumbprintKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+	key.KeyID = kid
+	return key, nil
+}
@@ -0,0 +1,109 @@
+package jwk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"testing"
+)
+
+func TestPEMRoundTrip(t *testing.T) {
+	cases := []struct {
+		key       interface{}
+		encodings []KeyEncoding
+	}{
+		{&rsaTestKey.PublicKey, []KeyEncoding{PKCS1, PKIX}},
+		{rsaTestKey, []KeyEncoding{PKCS1, PKCS8}},
+		{&ecTestKey256.PublicKey, []KeyEncoding{PKIX}},
+		{ecTestKey384, []KeyEncoding{PKCS8, SEC1}},
+		{ed25519TestPublicKey, []KeyEncoding{PKIX}},
+		{ed25519TestKey, []KeyEncoding{PKCS8}},
+		{x25519TestKey.PublicKey(), []KeyEncoding{PKIX}},
+		{x25519TestKey, []KeyEncoding{PKCS8}},
+	}
+
+	for _, tc := range cases {
+		jwk := JSONWebKey{Key: tc.key}
+		expected, err := jwk.MarshalJSON()
+		assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+
+		for _, encoding := range tc.encodings {
+			data, err := jwk.ToPEM(encoding)
+			assert(t, err == nil, fmt.Sprintf("problem encoding %T to %s: %s", tc.key, encoding, err))
+
+			jwk2, err := FromPEM(data)
+			assert(t, err == nil, fmt.Sprintf("problem decoding %T from %s: %s", tc.key, encoding, err))
+			tp, _ := thumbprintKeyID(&jwk)
+			assert(t, jwk2.KeyID == tp, "kid should be the key thumbprint")
+
+			jwk2.KeyID = ""
+			actual, err := jwk2.MarshalJSON()
+			assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+			assert(t, bytes.Equal(expected, actual), fmt.Sprintf("%T should not lose info through %s", tc.key, encoding))
+
+			block, _ := pem.Decode(data)
+			jwk3, err := FromDER(block.Bytes)
+			assert(t, err == nil, fmt.Sprintf("problem decoding %T from %s DER: %s", tc.key, encoding, err))
+			jwk3.KeyID = ""
+			actual, _ = jwk3.MarshalJSON()
+			assert(t, bytes.Equal(expected, actual), fmt.Sprintf("%T should not lose info through %s DER", tc.key, encoding))
+		}
+	}
+}
+
+func TestPEMUnsupportedEncoding(t *testing.T) {
+	cases := []struct {
+		key      interface{}
+		encoding KeyEncoding
+	}{
+		{&ecTestKey256.PublicKey, PKCS1},
+		{&rsaTestKey.PublicKey, PKCS8},
+		{rsaTestKey, SEC1},
+		{make([]byte, 32), PKIX},
+		{make([]byte, 32), PKCS8},
+		{rsaTestKey, KeyEncoding(0)},
+	}
+
+	for _, tc := range cases {
+		jwk := JSONWebKey{Key: tc.key}
+		_, err := jwk.ToPEM(tc.encoding)
+		assert(t, err != nil, fmt.Sprintf("managed to encode %T to %s", tc.key, tc.encoding))
+	}
+
+	_, err := FromPEM([]byte("not a PEM"))
+	assert(t, err != nil, "managed to decode invalid PEM")
+	_, err = FromDER([]byte("not a DER"))
+	assert(t, err != nil, "managed to decode invalid DER")
+}
+
+func TestFromCertificate(t *testing.T) {
+	root := createTestCertificate(t, &ecTestKey384.PublicKey, ecTestKey384, nil, true)
+	leaf := createTestCertificate(t, &ecTestKey256.PublicKey, ecTestKey384, root, false)
+
+	var data []byte
+	for _, cert := range []*x509.Certificate{leaf, root} {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	jwk, err := FromPEM(data)
+	assert(t, err == nil, fmt.Sprintf("problem decoding certificates %s", err))
+	assert(t, len(jwk.Certificates) == 2, fmt.Sprintf("it should return two certificates not %d", len(jwk.Certificates)))
+	assert(t, ecTestKey256.PublicKey.Equal(jwk.Key), "key should be the leaf public key")
+
+	sum := sha256.Sum256(leaf.Raw)
+	assert(t, bytes.Equal(jwk.CertificateThumbprintSHA256, sum[:]), "x5t#S256 should be the leaf thumbprint")
+	tp, _ := thumbprintKeyID(jwk)
+	assert(t, jwk.KeyID == tp, "kid should be the key thumbprint")
+
+	jsonbar, err := jwk.MarshalJSON()
+	assert(t, err == nil, fmt.Sprintf("problem marshalling %s", err))
+	var jwk2 JSONWebKey
+	err = jwk2.UnmarshalJSON(jsonbar)
+	assert(t, err == nil, fmt.Sprintf("problem unmarshalling %s", err))
+
+	jwk3, err := FromDER(leaf.Raw)
+	assert(t, err == nil, fmt.Sprintf("problem decoding certificate DER %s", err))
+	assert(t, len(jwk3.Certificates) == 1, "it should return one certificate")
+}
@@ -0,0 +1,111 @@
+package jwk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+type (
+	// RetryPolicy configures retries of failed fetches, the zero
+	// RetryPolicy disables them. Start retries before returning,
+	// periodic updates schedule the next fetch after the retry delay.
+	// Only timeouts, connection failures, 5xx and 429 responses are retried.
+	RetryPolicy struct {
+		// InitialDelay is the delay before the first retry.
+		InitialDelay time.Duration
+		// MaxDelay caps retry delays, including Retry-After ones.
+		MaxDelay time.Duration
+		// Multiplier grows the delay after each retry.
+		Multiplier float64
+		// Jitter randomizes delays by up to this fraction, e.g. 0.2 for ±20%.
+		Jitter float64
+		// MaxAttempts is the maximum number of attempts including the first.
+		MaxAttempts int
+	}
+
+	// HTTPError is returned when the JWKS endpoint replies an error status.
+	HTTPError struct {
+		StatusCode int
+		// RetryAfter is the delay from the Retry-After header, if any.
+		RetryAfter time.Duration
+	}
+)
+
+var (
+	// DefaultRetryPolicy is the default Retry Policy.
+	DefaultRetryPolicy = RetryPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     time.Minute,
+		Multiplier:   2,
+		Jitter:       0.2,
+		MaxAttempts:  5,
+	}
+)
+
+// Error returns the status code.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("fetchJWKS request returned non-success StatusCode %d", e.StatusCode)
+}
+
+// delay returns the delay before retrying after attempt failed with err,
+// ok is false if err should not be retried.
+func (p RetryPolicy) delay(attempt int, err error) (d time.Duration, ok bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	retryAfter, ok := retriable(err)
+	if !ok {
+		return 0, false
+	}
+
+	multiplier := math.Max(p.Multiplier, 1)
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	d = time.Duration(math.Min(delay, float64(math.MaxInt64)))
+	if d < retryAfter {
+		d = retryAfter
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d, true
+}
+
+// retriable reports whether err is transient, a timeout, a connection
+// failure, a 5xx or 429 response, with the Retry-After delay if any.
+func retriable(err error) (retryAfter time.Duration, ok bool) {
+	var httpErr *HTTPError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &httpErr):
+		if httpErr.StatusCode != http.StatusTooManyRequests && httpErr.StatusCode < 500 {
+			return 0, false
+		}
+		return httpErr.RetryAfter, true
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.As(err, &opErr):
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// parseRetryAfter parses a Retry-After header value,
+// either delay seconds or a HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if d, err := parseDeltaSeconds(value); err == nil {
+		return d
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package jwk
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     time.Minute,
+		Multiplier:   2,
+		MaxAttempts:  5,
+	}
+	timeout := &net.DNSError{Err: "timeout", IsTimeout: true}
+	tests := []struct {
+		attempt int
+		err     error
+		d       time.Duration
+		ok      bool
+	}{
+		{1, &HTTPError{StatusCode: 503}, time.Second, true},
+		{3, &HTTPError{StatusCode: 500}, 4 * time.Second, true},
+		{4, fmt.Errorf("wrapped: %w", timeout), 8 * time.Second, true},
+		{1, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, time.Second, true},
+		{1, &HTTPError{StatusCode: 429, RetryAfter: 30 * time.Second}, 30 * time.Second, true},
+		{1, &HTTPError{StatusCode: 429, RetryAfter: time.Hour}, time.Minute, true},
+		{5, &HTTPError{StatusCode: 503}, 0, false},
+		{1, &HTTPError{StatusCode: 404}, 0, false},
+		{1, ErrMalformedKey, 0, false},
+	}
+
+	for i, test := range tests {
+		d, ok := policy.delay(test.attempt, test.err)
+		assert(t, d == test.d && ok == test.ok, fmt.Sprintf("test %d: delay returned %s %v", i, d, ok))
+	}
+
+	policy.MaxAttempts = 20
+	d, ok := policy.delay(19, timeout)
+	assert(t, ok && d == time.Minute, fmt.Sprintf("delay should be capped at MaxDelay not %s", d))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d, _ := policy.delay(1, timeout)
+		assert(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, fmt.Sprintf("delay out of jitter range %s", d))
+	}
+
+	_, ok = RetryPolicy{}.delay(1, timeout)
+	assert(t, !ok, "zero RetryPolicy should not retry")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert(t, parseRetryAfter("120", now) == 2*time.Minute, "it should parse delay seconds")
+	assert(t, parseRetryAfter("Wed, 01 Jan 2020 00:01:00 GMT", now) == time.Minute, "it should parse HTTP date")
+	assert(t, parseRetryAfter("Tue, 31 Dec 2019 00:00:00 GMT", now) == 0, "past HTTP date should not delay")
+	assert(t, parseRetryAfter("soon", now) == 0, "invalid value should not delay")
+	assert(t, parseRetryAfter("", now) == 0, "empty value should not delay")
+}
@@ -0,0 +1,188 @@
+package jwk
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyNotFound is returned when no key matches a lookup.
+var ErrKeyNotFound = errors.New("Key not found")
+
+// KeySelector filters keys in a JSONWebKeySet, zero fields match any key.
+// Keys without alg, use or key_ops match any compatible value.
+type KeySelector struct {
+	KeyID     string
+	Algorithm string
+	Use       string
+	// KeyOps lists operations the key must all allow.
+	KeyOps []string
+	// KeyType is one of RSA, EC, OKP or oct.
+	KeyType                     string
+	CertificateThumbprintSHA1   []byte
+	CertificateThumbprintSHA256 []byte
+	// Thumbprint is the RFC 7638 SHA-256 thumbprint of the key.
+	Thumbprint []byte
+}
+
+// KeyType returns the JWK kty of the given key, one of RSA, EC, OKP or oct.
+func (key *JSONWebKey) KeyType() string {
+	return keyType(key.Key)
+}
+
+// Select returns keys matching the given selector.
+func (set *JSONWebKeySet) Select(selector KeySelector) []JSONWebKey {
+	var keys []JSONWebKey
+	for _, key := range set.Keys {
+		if selector.match(&key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// RSAPublicKey returns the RSA public key with the given key ID.
+func (set *JSONWebKeySet) RSAPublicKey(kid string) (*rsa.PublicKey, error) {
+	k, err := set.typedKey(kid, "RSA public", func(k interface{}) bool {
+		_, ok := publicKey(k).(*rsa.PublicKey)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publicKey(k).(*rsa.PublicKey), nil
+}
+
+// ECDSAPublicKey returns the EC public key with the given key ID.
+func (set *JSONWebKeySet) ECDSAPublicKey(kid string) (*ecdsa.PublicKey, error) {
+	k, err := set.typedKey(kid, "EC public", func(k interface{}) bool {
+		_, ok := publicKey(k).(*ecdsa.PublicKey)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publicKey(k).(*ecdsa.PublicKey), nil
+}
+
+// Ed25519PublicKey returns the Ed25519 public key with the given key ID.
+func (set *JSONWebKeySet) Ed25519PublicKey(kid string) (ed25519.PublicKey, error) {
+	k, err := set.typedKey(kid, "Ed25519 public", func(k interface{}) bool {
+		_, ok := publicKey(k).(ed25519.PublicKey)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publicKey(k).(ed25519.PublicKey), nil
+}
+
+// SymmetricKey returns the symmetric key with the given key ID.
+func (set *JSONWebKeySet) SymmetricKey(kid string) ([]byte, error) {
+	k, err := set.typedKey(kid, "symmetric", func(k interface{}) bool {
+		_, ok := k.([]byte)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k.([]byte), nil
+}
+
+// typedKey returns the first key with the given key ID accepted by ok.
+func (set *JSONWebKeySet) typedKey(kid, name string, ok func(interface{}) bool) (interface{}, error) {
+	keys := set.Key(kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+	}
+
+	for _, key := range keys {
+		if ok(key.Key) {
+			return key.Key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Key '%s' is not a %s key", kid, name)
+}
+
+func (selector *KeySelector) match(key *JSONWebKey) bool {
+	if selector.KeyID != "" && selector.KeyID != key.KeyID {
+		return false
+	}
+
+	kty := key.KeyType()
+	if selector.KeyType != "" && selector.KeyType != kty {
+		return false
+	}
+
+	if selector.Algorithm != "" {
+		if key.Algorithm != "" && key.Algorithm != selector.Algorithm {
+			return false
+		}
+		if key.Algorithm == "" && !contains(algKeyTypes[selector.Algorithm], kty) {
+			return false
+		}
+	}
+
+	if selector.Use != "" && key.Use != "" && key.Use != selector.Use {
+		return false
+	}
+
+	for _, op := range selector.KeyOps {
+		if len(key.KeyOps) > 0 && !contains(key.KeyOps, op) {
+			return false
+		}
+		if uses, ok := keyOpsUse[op]; ok && key.Use != "" && key.Use != uses {
+			return false
+		}
+	}
+
+	if len(selector.CertificateThumbprintSHA1) > 0 &&
+		!bytes.Equal(selector.CertificateThumbprintSHA1, key.certificateThumbprint(crypto.SHA1)) {
+		return false
+	}
+
+	if len(selector.CertificateThumbprintSHA256) > 0 &&
+		!bytes.Equal(selector.CertificateThumbprintSHA256, key.certificateThumbprint(crypto.SHA256)) {
+		return false
+	}
+
+	if len(selector.Thumbprint) > 0 {
+		tp, err := key.Thumbprint(crypto.SHA256)
+		if err != nil || !bytes.Equal(selector.Thumbprint, tp) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// certificateThumbprint returns the x5t or x5t#S256 value of the given key,
+// computed from the x5c leaf certificate if unset.
+func (key *JSONWebKey) certificateThumbprint(hash crypto.Hash) []byte {
+	tp := key.CertificateThumbprintSHA256
+	if hash == crypto.SHA1 {
+		tp = key.CertificateThumbprintSHA1
+	}
+	if len(tp) > 0 || len(key.Certificates) == 0 {
+		return tp
+	}
+
+	h := hash.New()
+	h.Write(key.Certificates[0].Raw)
+	return h.Sum(nil)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
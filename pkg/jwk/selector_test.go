@@ -0,0 +1,74 @@
+package jwk
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	rsaKey := JSONWebKey{Key: &rsaTestKey.PublicKey, KeyID: "rsa", Algorithm: "RS256", Use: "sig", Certificates: testCertificates}
+	ecKey := JSONWebKey{Key: &ecTestKey256.PublicKey, KeyID: "ec", Use: "sig", KeyOps: []string{"verify"}}
+	encKey := JSONWebKey{Key: &ecTestKey384.PublicKey, KeyID: "ec", Use: "enc"}
+	edKey := JSONWebKey{Key: ed25519TestPublicKey, KeyID: "ed", Algorithm: "EdDSA"}
+	octKey := JSONWebKey{Key: make([]byte, 32), KeyID: "oct"}
+	set := JSONWebKeySet{Keys: []JSONWebKey{rsaKey, ecKey, encKey, edKey, octKey}}
+
+	ecThumbprint, _ := ecKey.Thumbprint(crypto.SHA256)
+
+	cases := []struct {
+		selector KeySelector
+		expected []string
+	}{
+		{KeySelector{}, []string{"rsa", "ec", "ec", "ed", "oct"}},
+		{KeySelector{KeyID: "ec"}, []string{"ec", "ec"}},
+		{KeySelector{KeyID: "ec", Use: "sig"}, []string{"ec"}},
+		{KeySelector{Algorithm: "RS256"}, []string{"rsa"}},
+		{KeySelector{Algorithm: "ES256"}, []string{"ec", "ec"}},
+		{KeySelector{Algorithm: "ES256", Use: "sig"}, []string{"ec"}},
+		{KeySelector{Algorithm: "HS256"}, []string{"oct"}},
+		{KeySelector{Use: "enc"}, []string{"ec", "ed", "oct"}},
+		{KeySelector{KeyOps: []string{"verify"}}, []string{"rsa", "ec", "ed", "oct"}},
+		{KeySelector{KeyOps: []string{"sign"}}, []string{"rsa", "ed", "oct"}},
+		{KeySelector{KeyType: "OKP"}, []string{"ed"}},
+		{KeySelector{Thumbprint: ecThumbprint}, []string{"ec"}},
+		{KeySelector{CertificateThumbprintSHA1: rsaKey.certificateThumbprint(crypto.SHA1)}, []string{"rsa"}},
+		{KeySelector{CertificateThumbprintSHA256: rsaKey.certificateThumbprint(crypto.SHA256)}, []string{"rsa"}},
+		{KeySelector{KeyID: "rsa", KeyType: "EC"}, nil},
+	}
+
+	for _, tc := range cases {
+		var kids []string
+		for _, key := range set.Select(tc.selector) {
+			kids = append(kids, key.KeyID)
+		}
+		assert(t, fmt.Sprint(kids) == fmt.Sprint(tc.expected),
+			fmt.Sprintf("selector %+v expected %v, got %v", tc.selector, tc.expected, kids))
+	}
+}
+
+func TestTypedKeys(t *testing.T) {
+	set := JSONWebKeySet{Keys: []JSONWebKey{
+		{Key: rsaTestKey, KeyID: "rsa"},
+		{Key: &ecTestKey256.PublicKey, KeyID: "ec"},
+		{Key: ed25519TestPublicKey, KeyID: "ed"},
+		{Key: make([]byte, 32), KeyID: "oct"},
+	}}
+
+	rsaKey, err := set.RSAPublicKey("rsa")
+	assert(t, err == nil && rsaKey.Equal(&rsaTestKey.PublicKey), fmt.Sprintf("problem getting RSA key %s", err))
+	ecKey, err := set.ECDSAPublicKey("ec")
+	assert(t, err == nil && ecKey.Equal(&ecTestKey256.PublicKey), fmt.Sprintf("problem getting EC key %s", err))
+	edKey, err := set.Ed25519PublicKey("ed")
+	assert(t, err == nil && edKey.Equal(ed25519TestPublicKey), fmt.Sprintf("problem getting Ed25519 key %s", err))
+	octKey, err := set.SymmetricKey("oct")
+	assert(t, err == nil && len(octKey) == 32, fmt.Sprintf("problem getting oct key %s", err))
+
+	_, err = set.RSAPublicKey("missing")
+	assert(t, errors.Is(err, ErrKeyNotFound), fmt.Sprintf("it should return ErrKeyNotFound not %s", err))
+	_, err = set.RSAPublicKey("ec")
+	assert(t, err != nil && !errors.Is(err, ErrKeyNotFound), "it should fail on wrong key type")
+	_, err = set.SymmetricKey("rsa")
+	assert(t, err != nil, "it should fail on wrong key type")
+}
@@ -0,0 +1,335 @@
+package jwk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Source loads JWKS for a Client.
+	Source interface {
+		// Fetch returns the JWKS, previous is the last successful result,
+		// or nil, it can be used to reply NotModified.
+		Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error)
+		// String describes the source, it identifies cached JWKS.
+		String() string
+	}
+
+	// FetchResult is the JWKS loaded by a Source.
+	FetchResult struct {
+		// Body is the JWKS JSON document.
+		Body []byte
+		// NotModified reports the previous result is unchanged,
+		// Body is ignored.
+		NotModified bool
+		// ETag and LastModified are opaque validators of Body.
+		ETag         string
+		LastModified string
+		// Expires is when to refresh, CacheTimeout is used if zero.
+		Expires time.Time
+	}
+
+	// SourceFunc is a Source loading JWKS by calling itself.
+	SourceFunc func(ctx context.Context, previous *FetchResult) (*FetchResult, error)
+
+	// HTTPSource fetches JWKS from an HTTP endpoint, with conditional requests
+	// and refreshes scheduled by the Cache-Control and Expires headers.
+	HTTPSource struct {
+		URL string
+		// Mirrors are URLs serving the same JWKS, tried in order if
+		// a fetch fails, the last URL to succeed is tried first.
+		Mirrors []string
+		Client  *http.Client
+		Headers map[string]string
+
+		mutex     sync.Mutex
+		preferred int
+		health    []EndpointStatus
+	}
+
+	// EndpointStatus reports the health of an HTTPSource URL.
+	EndpointStatus struct {
+		URL string
+		// Healthy is true if the last fetch from URL succeeded.
+		Healthy             bool
+		LastSuccess         time.Time
+		LastFailure         time.Time
+		LastError           error
+		ConsecutiveFailures int
+	}
+
+	// FileSource loads JWKS from a local file, its content is
+	// polled for changes every PollInterval, or CacheTimeout if zero.
+	// Like response lifetimes, PollInterval is clamped to the Client
+	// MinRefreshInterval, a minute by default, and MaxRefreshInterval.
+	FileSource struct {
+		Path         string
+		PollInterval time.Duration
+	}
+
+	// FSSource loads JWKS from a file in a fs.FS such as embed.FS.
+	FSSource struct {
+		FS   fs.FS
+		Path string
+	}
+
+	// CommandSource loads JWKS from the output of a command.
+	CommandSource struct {
+		Name string
+		Args []string
+	}
+
+	// MemorySource loads JWKS from an in-memory key set.
+	MemorySource struct {
+		mutex sync.RWMutex
+		body  []byte
+	}
+)
+
+// Fetch calls f(ctx, previous).
+func (f SourceFunc) Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+	return f(ctx, previous)
+}
+
+// String returns "func".
+func (f SourceFunc) String() string {
+	return "func"
+}
+
+// Fetch sends a GET request, conditional if previous has validators,
+// to the preferred URL then to the others in order until one succeeds.
+func (s *HTTPSource) Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+	urls := s.urls()
+	s.mutex.Lock()
+	if len(s.health) != len(urls) {
+		s.health = make([]EndpointStatus, len(urls))
+		s.preferred = 0
+	}
+	preferred := s.preferred
+	s.mutex.Unlock()
+
+	var err error
+	for n := 0; n < len(urls); n++ {
+		// preferred URL first, then the others in order
+		i := n
+		if n == 0 {
+			i = preferred
+		} else if n <= preferred {
+			i = n - 1
+		}
+
+		var result *FetchResult
+		result, err = s.fetch(ctx, urls[i], previous)
+		s.record(i, err)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// Endpoints returns the health of URL and Mirrors.
+func (s *HTTPSource) Endpoints() []EndpointStatus {
+	urls := s.urls()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	endpoints := make([]EndpointStatus, len(urls))
+	copy(endpoints, s.health)
+	for i := range endpoints {
+		endpoints[i].URL = urls[i]
+	}
+	return endpoints
+}
+
+func (s *HTTPSource) urls() []string {
+	return append([]string{s.URL}, s.Mirrors...)
+}
+
+// record records the result of a fetch from the i-th URL,
+// a successful URL becomes the preferred one.
+func (s *HTTPSource) record(i int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	health := &s.health[i]
+	if err != nil {
+		health.Healthy = false
+		health.LastFailure = time.Now()
+		health.LastError = err
+		health.ConsecutiveFailures++
+		return
+	}
+	health.Healthy = true
+	health.LastSuccess = time.Now()
+	health.ConsecutiveFailures = 0
+	s.preferred = i
+}
+
+func (s *HTTPSource) fetch(ctx context.Context, url string, previous *FetchResult) (*FetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, methodGET, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Headers {
+		req.Header.Add(k, v)
+	}
+	conditional := previous != nil && (previous.ETag != "" || previous.LastModified != "")
+	if conditional && previous.ETag != "" {
+		req.Header.Set("If-None-Match", previous.ETag)
+	}
+	if conditional && previous.LastModified != "" {
+		req.Header.Set("If-Modified-Since", previous.LastModified)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
+
+	now := time.Now()
+	result := &FetchResult{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if d, ok := cacheLifetime(resp.Header, now); ok {
+		result.Expires = now.Add(d)
+	}
+
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		result.NotModified = true
+		return result, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now),
+		}
+	}
+
+	if result.Body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// String returns the endpoint URL.
+func (s *HTTPSource) String() string {
+	return s.URL
+}
+
+// Fetch reads the file, it is NotModified if its content is unchanged.
+func (s *FileSource) Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+	body, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	result := newFetchResult(body, previous)
+	if info, err := os.Stat(s.Path); err == nil {
+		result.LastModified = info.ModTime().UTC().Format(http.TimeFormat)
+	}
+	if s.PollInterval > 0 {
+		result.Expires = time.Now().Add(s.PollInterval)
+	}
+	return result, nil
+}
+
+// String returns the file path prefixed with "file:".
+func (s *FileSource) String() string {
+	return "file:" + s.Path
+}
+
+// Fetch reads the file, it is NotModified if its content is unchanged.
+func (s *FSSource) Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+	body, err := fs.ReadFile(s.FS, s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newFetchResult(body, previous), nil
+}
+
+// String returns the file name prefixed with "fs:".
+func (s *FSSource) String() string {
+	return "fs:" + s.Path
+}
+
+// Fetch runs the command, it is NotModified if its output is unchanged.
+func (s *CommandSource) Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+	body, err := exec.CommandContext(ctx, s.Name, s.Args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) != 0 {
+			return nil, fmt.Errorf("Command '%s' failed: %w: %s", s, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("Command '%s' failed: %w", s, err)
+	}
+	return newFetchResult(body, previous), nil
+}
+
+// String returns the command line prefixed with "command:".
+func (s *CommandSource) String() string {
+	return strings.Join(append([]string{"command:" + s.Name}, s.Args...), " ")
+}
+
+// NewMemorySource returns a MemorySource holding keySet.
+func NewMemorySource(keySet *JSONWebKeySet) (*MemorySource, error) {
+	s := &MemorySource{}
+	if err := s.Set(keySet); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the key set, it is loaded on the next refresh.
+// The key set may hold private and symmetric keys.
+func (s *MemorySource) Set(keySet *JSONWebKeySet) error {
+	body, err := keySet.MarshalSecretJSON()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.body = body
+	return nil
+}
+
+// Fetch returns the key set, it is NotModified if unchanged.
+func (s *MemorySource) Fetch(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return newFetchResult(s.body, previous), nil
+}
+
+// String returns "memory".
+func (s *MemorySource) String() string {
+	return "memory"
+}
+
+// newFetchResult returns the result for body, with its SHA-256 as ETag,
+// NotModified if it matches the previous ETag.
+func newFetchResult(body []byte, previous *FetchResult) *FetchResult {
+	sum := sha256.Sum256(body)
+	etag := hex.EncodeToString(sum[:])
+	if previous != nil && previous.ETag == etag {
+		return &FetchResult{NotModified: true, ETag: etag}
+	}
+	return &FetchResult{Body: body, ETag: etag}
+}
@@ -0,0 +1,211 @@
+package jwk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testSourceBody = `{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	source := &FileSource{Path: path, PollInterval: time.Minute}
+	_, err := source.Fetch(context.Background(), nil)
+	assert(t, err != nil, "Fetch should fail on missing file")
+
+	if err := ioutil.WriteFile(path, []byte(testSourceBody), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := source.Fetch(context.Background(), nil)
+	assert(t, err == nil && string(result.Body) == testSourceBody, fmt.Sprintf("fail to Fetch %s", err))
+	assert(t, result.ETag != "" && result.LastModified != "", "it should set validators")
+	assert(t, time.Until(result.Expires) > 59*time.Second, "it should expire after PollInterval")
+
+	previous := result
+	result, err = source.Fetch(context.Background(), previous)
+	assert(t, err == nil && result.NotModified, "unchanged file should not be modified")
+
+	if err := ioutil.WriteFile(path, []byte(`{"keys":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err = source.Fetch(context.Background(), previous)
+	assert(t, err == nil && !result.NotModified && string(result.Body) == `{"keys":[]}`, "changed file should be modified")
+	assert(t, source.String() == "file:"+path, "String should describe the file")
+}
+
+func TestFileSourcePollInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, []byte(testSourceBody), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source := &FileSource{Path: path, PollInterval: 5 * time.Second}
+
+	jwkClient, _ := NewClientWithSource(source)
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	next := time.Until(jwkClient.NextRefresh())
+	assert(t, next > 59*time.Second && next <= time.Minute, fmt.Sprintf("PollInterval should be clamped to MinRefreshInterval not %s", next))
+	jwkClient.Stop()
+
+	jwkClient, _ = NewClientWithSource(source, func(config *ClientConfig) error {
+		config.MinRefreshInterval = time.Second
+		return nil
+	})
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	next = time.Until(jwkClient.NextRefresh())
+	assert(t, next > 4*time.Second && next <= 5*time.Second, fmt.Sprintf("it should poll every PollInterval not %s", next))
+	jwkClient.Stop()
+}
+
+func TestFSSource(t *testing.T) {
+	source := &FSSource{FS: fstest.MapFS{"keys/jwks.json": {Data: []byte(testSourceBody)}}, Path: "keys/jwks.json"}
+	result, err := source.Fetch(context.Background(), nil)
+	assert(t, err == nil && string(result.Body) == testSourceBody, fmt.Sprintf("fail to Fetch %s", err))
+	result, err = source.Fetch(context.Background(), result)
+	assert(t, err == nil && result.NotModified, "unchanged file should not be modified")
+
+	source.Path = "keys/missing.json"
+	_, err = source.Fetch(context.Background(), nil)
+	assert(t, err != nil, "Fetch should fail on missing file")
+}
+
+func TestCommandSource(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	source := &CommandSource{Name: "sh", Args: []string{"-c", "printf '%s' '" + testSourceBody + "'"}}
+	result, err := source.Fetch(context.Background(), nil)
+	assert(t, err == nil && string(result.Body) == testSourceBody, fmt.Sprintf("fail to Fetch %s", err))
+	result, err = source.Fetch(context.Background(), result)
+	assert(t, err == nil && result.NotModified, "unchanged output should not be modified")
+
+	source = &CommandSource{Name: "sh", Args: []string{"-c", "echo denied >&2; exit 1"}}
+	_, err = source.Fetch(context.Background(), nil)
+	var exitErr *exec.ExitError
+	assert(t, errors.As(err, &exitErr) && strings.Contains(err.Error(), "denied"), fmt.Sprintf("it should report command failure not %s", err))
+}
+
+func TestMemorySourceClient(t *testing.T) {
+	source, err := NewMemorySource(&JSONWebKeySet{Keys: []JSONWebKey{{Key: &rsaTestKey.PublicKey, KeyID: "ABCDEFG"}}})
+	assert(t, err == nil, fmt.Sprintf("fail to NewMemorySource %s", err))
+
+	jwkClient, err := NewClientWithSource(source)
+	assert(t, err == nil, fmt.Sprintf("fail to NewClientWithSource %s", err))
+	var diffs []*KeySetDiff
+	jwkClient.Subscribe(Listener{
+		OnRefresh: func(old, current *JSONWebKeySet, diff *KeySetDiff) {
+			diffs = append(diffs, diff)
+		},
+	})
+	err = jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	jwkClient.ForceRefresh()
+	assert(t, len(diffs) == 2 && diffs[1].Empty(), "unchanged source should refresh with empty diff")
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	err = source.Set(&JSONWebKeySet{Keys: []JSONWebKey{
+		{Key: &rsaTestKey.PublicKey, KeyID: "ABCDEFG"},
+		{Key: secret, KeyID: "GFEDCBA", Algorithm: "HS256"},
+	}})
+	assert(t, err == nil, fmt.Sprintf("fail to Set %s", err))
+	jwkClient.ForceRefresh()
+	assert(t, len(diffs) == 3 && len(diffs[2].Added) == 1, "changed source should refresh with diff")
+
+	keySet := jwkClient.KeySet()
+	key, err := keySet.SymmetricKey("GFEDCBA")
+	assert(t, err == nil && string(key) == string(secret), fmt.Sprintf("it should load symmetric key %s", err))
+}
+
+func TestSourceFunc(t *testing.T) {
+	var calls int
+	source := SourceFunc(func(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+		calls++
+		if previous != nil {
+			return &FetchResult{NotModified: true}, nil
+		}
+		return &FetchResult{Body: []byte(testSourceBody)}, nil
+	})
+
+	jwkClient, _ := NewClientWithSource(source)
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+	jwkClient.ForceRefresh()
+	assert(t, calls == 2 && len(jwkClient.KeySet().Keys) == 1, "it should keep key set on not modified")
+
+	jwkClient, _ = NewClientWithSource(SourceFunc(func(ctx context.Context, previous *FetchResult) (*FetchResult, error) {
+		return &FetchResult{NotModified: true}, nil
+	}))
+	err = jwkClient.Start()
+	assert(t, err != nil, "Start should fail on not modified first fetch")
+}
+
+type mockMirrorTransport struct {
+	down     map[string]bool
+	requests []string
+}
+
+func (t *mockMirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req.URL.Host)
+	if t.down[req.URL.Host] {
+		return (&mockStatusTransport{http.StatusServiceUnavailable}).RoundTrip(req)
+	}
+	return (&mockBodyTransport{testSourceBody}).RoundTrip(req)
+}
+
+func TestHTTPSourceMirrors(t *testing.T) {
+	transport := &mockMirrorTransport{down: map[string]bool{"primary": true}}
+	jwkClient, _ := NewClient("http://primary/jwks", func(config *ClientConfig) error {
+		config.MirrorURLs = []string{"http://cdn1/jwks", "http://cdn2/jwks"}
+		config.RetryPolicy = RetryPolicy{}
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: transport}
+
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("Start should fall back to mirror %s", err))
+	defer jwkClient.Stop()
+	assert(t, strings.Join(transport.requests, ",") == "primary,cdn1", fmt.Sprintf("it should try URLs in order not %s", transport.requests))
+
+	endpoints := jwkClient.Status().Endpoints
+	assert(t, len(endpoints) == 3 && endpoints[0].URL == "http://primary/jwks", "it should report every URL")
+	assert(t, !endpoints[0].Healthy && endpoints[0].ConsecutiveFailures == 1 && endpoints[0].LastError != nil, "it should report failed URL")
+	assert(t, endpoints[1].Healthy && !endpoints[1].LastSuccess.IsZero(), "it should report healthy URL")
+	assert(t, !endpoints[2].Healthy && endpoints[2].LastFailure.IsZero(), "it should report untried URL")
+
+	// last healthy URL is preferred
+	transport.requests = nil
+	delete(transport.down, "primary")
+	jwkClient.ForceRefresh()
+	assert(t, strings.Join(transport.requests, ",") == "cdn1", fmt.Sprintf("it should prefer last healthy URL not %s", transport.requests))
+
+	transport.requests = nil
+	transport.down["cdn1"] = true
+	transport.down["primary"] = true
+	jwkClient.ForceRefresh()
+	assert(t, strings.Join(transport.requests, ",") == "cdn1,primary,cdn2", fmt.Sprintf("it should fall back in order not %s", transport.requests))
+	assert(t, jwkClient.Status().ConsecutiveFailures == 0, "refresh should succeed on mirror")
+
+	transport.requests = nil
+	transport.down["cdn2"] = true
+	err = jwkClient.Refresh(context.Background())
+	var httpErr *HTTPError
+	assert(t, errors.As(err, &httpErr), fmt.Sprintf("Refresh should fail when all URLs fail, not %s", err))
+	assert(t, strings.Join(transport.requests, ",") == "cdn2,primary,cdn1", fmt.Sprintf("it should try every URL not %s", transport.requests))
+	for _, endpoint := range jwkClient.Status().Endpoints {
+		assert(t, !endpoint.Healthy, fmt.Sprintf("%s should be unhealthy", endpoint.URL))
+	}
+}
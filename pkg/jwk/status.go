@@ -0,0 +1,99 @@
+package jwk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type (
+	// ClientStatus reports the health of a Client.
+	ClientStatus struct {
+		// LastSuccess is the time of the last successful fetch,
+		// zero if none.
+		LastSuccess time.Time
+		// LastFailure and LastError report the last failed fetch.
+		LastFailure         time.Time
+		LastError           error
+		ConsecutiveFailures int
+		KeyCount            int
+		NextRefresh         time.Time
+		// Stale is true if cached keys are older than MaxStaleness.
+		Stale bool
+		// Endpoints is the health of each URL of an HTTPSource.
+		Endpoints []EndpointStatus
+	}
+
+	// fetchStatus is the part of ClientStatus updated by fetches.
+	fetchStatus struct {
+		lastFailure time.Time
+		lastError   error
+		failures    int
+	}
+)
+
+var (
+	// ErrStaleKeySet is returned by lookups on a Client
+	// whose keys are older than MaxStaleness.
+	ErrStaleKeySet = errors.New("Stale key set")
+)
+
+// Status returns the current health of the client.
+func (client *Client) Status() ClientStatus {
+	client.mutex.RLock()
+	status := ClientStatus{
+		LastFailure:         client.fetchStatus.lastFailure,
+		LastError:           client.fetchStatus.lastError,
+		ConsecutiveFailures: client.fetchStatus.failures,
+	}
+	client.mutex.RUnlock()
+
+	status.LastSuccess = client.lastSuccess()
+	status.KeyCount = len(client.loadSnapshot().set.Keys)
+	status.NextRefresh = client.NextRefresh()
+	status.Stale = client.checkStale() != nil
+	if source, ok := client.source.(*HTTPSource); ok {
+		status.Endpoints = source.Endpoints()
+	}
+	return status
+}
+
+func (client *Client) lastSuccess() time.Time {
+	if nsec := client.fetchedAt.Load(); nsec != 0 {
+		return time.Unix(0, nsec)
+	}
+	return time.Time{}
+}
+
+// recordSuccess records a successful fetch at t.
+func (client *Client) recordSuccess(t time.Time) {
+	client.fetchedAt.Store(t.UnixNano())
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.fetchStatus.failures = 0
+}
+
+// recordFailure records a failed fetch,
+// it returns the number of consecutive failures.
+func (client *Client) recordFailure(err error) int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.fetchStatus.lastFailure = time.Now()
+	client.fetchStatus.lastError = err
+	client.fetchStatus.failures++
+	return client.fetchStatus.failures
+}
+
+// checkStale returns ErrStaleKeySet if fetched keys are older than MaxStaleness.
+func (client *Client) checkStale() error {
+	maxStaleness := client.config.MaxStaleness
+	last := client.lastSuccess()
+	if maxStaleness <= 0 || last.IsZero() {
+		return nil
+	}
+	if age := time.Since(last); age > maxStaleness {
+		return fmt.Errorf("%w: last fetched %s ago", ErrStaleKeySet, age.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package jwk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientStatus(t *testing.T) {
+	body := &mockBodyTransport{`{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`}
+
+	jwkClient, _ := NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.RetryPolicy = RetryPolicy{}
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: body}
+	status := jwkClient.Status()
+	assert(t, status.LastSuccess.IsZero() && status.KeyCount == 0 && !status.Stale, "status before Start")
+
+	before := time.Now()
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	status = jwkClient.Status()
+	assert(t, !status.LastSuccess.Before(before), "it should report last success")
+	assert(t, status.KeyCount == 1, fmt.Sprintf("it should report key count not %d", status.KeyCount))
+	assert(t, status.NextRefresh.Equal(jwkClient.NextRefresh()), "it should report next refresh")
+	assert(t, status.LastError == nil && status.ConsecutiveFailures == 0, "it should report no failure")
+
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: &mockStatusTransport{http.StatusBadGateway}}
+	jwkClient.ForceRefresh()
+	jwkClient.ForceRefresh()
+	status = jwkClient.Status()
+	var httpErr *HTTPError
+	assert(t, errors.As(status.LastError, &httpErr) && httpErr.StatusCode == http.StatusBadGateway, fmt.Sprintf("it should report last error not %s", status.LastError))
+	assert(t, status.ConsecutiveFailures == 2, fmt.Sprintf("it should report 2 failures not %d", status.ConsecutiveFailures))
+	assert(t, !status.LastFailure.Before(status.LastSuccess), "it should report last failure")
+	assert(t, status.KeyCount == 1, "it should keep key set on fetch failure")
+
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: body}
+	jwkClient.ForceRefresh()
+	status = jwkClient.Status()
+	assert(t, status.ConsecutiveFailures == 0 && status.LastError != nil, "success should reset failures only")
+}
+
+func TestMaxStaleness(t *testing.T) {
+	body := &mockBodyTransport{`{"keys":[{"kty":"RSA","kid":"ABCDEFG","n":"` + testCertificateModulus + `","e":"AQAB"}]}`}
+
+	jwkClient, _ := NewClient("http://andy2046.io", func(config *ClientConfig) error {
+		config.MaxStaleness = time.Hour
+		return nil
+	})
+	jwkClient.source.(*HTTPSource).Client = &http.Client{Transport: body}
+	err := jwkClient.Start()
+	assert(t, err == nil, fmt.Sprintf("fail to Start %s", err))
+	defer jwkClient.Stop()
+
+	_, err = jwkClient.FreshKeySet()
+	assert(t, err == nil, fmt.Sprintf("fresh key set should not be stale %s", err))
+
+	jwkClient.fetchedAt.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	assert(t, jwkClient.Status().Stale, "it should report stale key set")
+	_, err = jwkClient.FreshKeySet()
+	assert(t, errors.Is(err, ErrStaleKeySet), fmt.Sprintf("FreshKeySet should return ErrStaleKeySet not %s", err))
+	assert(t, len(jwkClient.KeySet().Keys) == 1, "KeySet should return stale key set")
+	_, err = jwkClient.Key("ABCDEFG")
+	assert(t, errors.Is(err, ErrStaleKeySet), fmt.Sprintf("Key should return ErrStaleKeySet not %s", err))
+	_, err = jwkClient.GetKey(context.Background(), "ABCDEFG")
+	assert(t, errors.Is(err, ErrStaleKeySet), fmt.Sprintf("GetKey should return ErrStaleKeySet not %s", err))
+
+	jwkClient.ForceRefresh()
+	assert(t, !jwkClient.Status().Stale, "refresh should clear stale key set")
+	_, err = jwkClient.Key("ABCDEFG")
+	assert(t, err == nil, fmt.Sprintf("fail to get Key %s", err))
+}
@@ -2,6 +2,11 @@ package jwk
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -9,12 +14,100 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-const rsaThumbprintTemplate = `{"e":"%s","kty":"RSA","n":"%s"}`
+const (
+	rsaThumbprintTemplate = `{"e":"%s","kty":"RSA","n":"%s"}`
+	ecThumbprintTemplate  = `{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`
+	okpThumbprintTemplate = `{"crv":"%s","kty":"OKP","x":"%s"}`
+	octThumbprintTemplate = `{"k":"%s","kty":"oct"}`
+
+	x25519KeySize = 32
+
+	// minOctKeySize is the minimum size in bytes of a symmetric key
+	// whose algorithm is unknown.
+	minOctKeySize = 16
+)
+
+// octKeySizes maps symmetric algorithms to their minimum key size in bytes.
+var octKeySizes = map[string]int{
+	"HS256":     32,
+	"HS384":     48,
+	"HS512":     64,
+	"A128KW":    16,
+	"A192KW":    24,
+	"A256KW":    32,
+	"A128GCMKW": 16,
+	"A192GCMKW": 24,
+	"A256GCMKW": 32,
+}
+
+// algKeyTypes maps registered algorithms to the key types they apply to.
+var algKeyTypes = map[string][]string{
+	"RS256":          {"RSA"},
+	"RS384":          {"RSA"},
+	"RS512":          {"RSA"},
+	"PS256":          {"RSA"},
+	"PS384":          {"RSA"},
+	"PS512":          {"RSA"},
+	"RSA1_5":         {"RSA"},
+	"RSA-OAEP":       {"RSA"},
+	"RSA-OAEP-256":   {"RSA"},
+	"ES256":          {"EC"},
+	"ES384":          {"EC"},
+	"ES512":          {"EC"},
+	"EdDSA":          {"OKP"},
+	"ECDH-ES":        {"EC", "OKP"},
+	"ECDH-ES+A128KW": {"EC", "OKP"},
+	"ECDH-ES+A192KW": {"EC", "OKP"},
+	"ECDH-ES+A256KW": {"EC", "OKP"},
+	"HS256":          {"oct"},
+	"HS384":          {"oct"},
+	"HS512":          {"oct"},
+	"A128KW":         {"oct"},
+	"A192KW":         {"oct"},
+	"A256KW":         {"oct"},
+	"A128GCMKW":      {"oct"},
+	"A192GCMKW":      {"oct"},
+	"A256GCMKW":      {"oct"},
+	"dir":            {"oct"},
+}
+
+// keyOpsUse maps registered key_ops values to the use they belong to.
+var keyOpsUse = map[string]string{
+	"sign":       "sig",
+	"verify":     "sig",
+	"encrypt":    "enc",
+	"decrypt":    "enc",
+	"wrapKey":    "enc",
+	"unwrapKey":  "enc",
+	"deriveKey":  "enc",
+	"deriveBits": "enc",
+}
+
+// publicOps maps key_ops values to the ones a public key can perform.
+var publicOps = map[string]string{
+	"sign":      "verify",
+	"verify":    "verify",
+	"decrypt":   "encrypt",
+	"encrypt":   "encrypt",
+	"unwrapKey": "wrapKey",
+	"wrapKey":   "wrapKey",
+}
+
+var (
+	// jwkMembers are the JWK members decoded into rawJSONWebKey.
+	jwkMembers = jsonMembers(rawJSONWebKey{})
+	// jwksMembers are the JWK Set members decoded into rawJSONWebKeySet.
+	jwksMembers = jsonMembers(rawJSONWebKeySet{})
+)
 
 // byteBuffer represents url-safe base64 serializable bytes data.
 type byteBuffer struct {
@@ -30,6 +123,15 @@ func newBuffer(data []byte) *byteBuffer {
 	}
 }
 
+// newFixedSizeBuffer left pads data with zeros up to length bytes.
+func newFixedSizeBuffer(data []byte, length int) *byteBuffer {
+	if len(data) > length {
+		panic("jwk: invalid call to newFixedSizeBuffer (len(data) > length)")
+	}
+	pad := make([]byte, length-len(data))
+	return newBuffer(append(pad, data...))
+}
+
 func newBufferFromInt(num uint64) *byteBuffer {
 	data := make([]byte, 8)
 	binary.BigEndian.PutUint64(data, num)
@@ -86,6 +188,30 @@ func rsaThumbprintInput(n *big.Int, e int) (string, error) {
 		newBuffer(n.Bytes()).base64()), nil
 }
 
+func ecThumbprintInput(curve elliptic.Curve, x, y *big.Int) (string, error) {
+	crv, err := curveName(curve)
+	if err != nil {
+		return "", err
+	}
+
+	size := curveSize(curve)
+	if len(x.Bytes()) > size || len(y.Bytes()) > size {
+		return "", fmt.Errorf("Invalid EC key, coordinates too large for curve %s", crv)
+	}
+
+	return fmt.Sprintf(ecThumbprintTemplate, crv,
+		newFixedSizeBuffer(x.Bytes(), size).base64(),
+		newFixedSizeBuffer(y.Bytes(), size).base64()), nil
+}
+
+func okpThumbprintInput(crv string, x []byte) (string, error) {
+	return fmt.Sprintf(okpThumbprintTemplate, crv, newBuffer(x).base64()), nil
+}
+
+func octThumbprintInput(k []byte) (string, error) {
+	return fmt.Sprintf(octThumbprintTemplate, newBuffer(k).base64()), nil
+}
+
 func fromRsaPublicKey(pub *rsa.PublicKey) *rawJSONWebKey {
 	return &rawJSONWebKey{
 		Kty: "RSA",
@@ -94,6 +220,231 @@ func fromRsaPublicKey(pub *rsa.PublicKey) *rawJSONWebKey {
 	}
 }
 
+func fromRsaPrivateKey(priv *rsa.PrivateKey) (*rawJSONWebKey, error) {
+	if priv.N == nil || priv.D == nil {
+		return nil, fmt.Errorf("Invalid RSA private key, missing n/d values")
+	}
+	if len(priv.Primes) != 2 {
+		return nil, fmt.Errorf("Invalid RSA private key, only two primes are supported")
+	}
+
+	p, q := priv.Primes[0], priv.Primes[1]
+	one := big.NewInt(1)
+	dp := new(big.Int).Mod(priv.D, new(big.Int).Sub(p, one))
+	dq := new(big.Int).Mod(priv.D, new(big.Int).Sub(q, one))
+	qi := new(big.Int).ModInverse(q, p)
+	if qi == nil {
+		return nil, fmt.Errorf("Invalid RSA private key, primes are not coprime")
+	}
+
+	raw := fromRsaPublicKey(&priv.PublicKey)
+	raw.D = newBuffer(priv.D.Bytes())
+	raw.P = newBuffer(p.Bytes())
+	raw.Q = newBuffer(q.Bytes())
+	raw.Dp = newBuffer(dp.Bytes())
+	raw.Dq = newBuffer(dq.Bytes())
+	raw.Qi = newBuffer(qi.Bytes())
+
+	return raw, nil
+}
+
+func fromEcPublicKey(pub *ecdsa.PublicKey) (*rawJSONWebKey, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return nil, fmt.Errorf("Invalid EC key, missing x/y values")
+	}
+
+	crv, err := curveName(pub.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	size := curveSize(pub.Curve)
+	xBytes, yBytes := pub.X.Bytes(), pub.Y.Bytes()
+	if len(xBytes) > size || len(yBytes) > size {
+		return nil, fmt.Errorf("Invalid EC key, coordinates too large for curve %s", crv)
+	}
+
+	return &rawJSONWebKey{
+		Kty: "EC",
+		Crv: crv,
+		X:   newFixedSizeBuffer(xBytes, size),
+		Y:   newFixedSizeBuffer(yBytes, size),
+	}, nil
+}
+
+func fromEcPrivateKey(priv *ecdsa.PrivateKey) (*rawJSONWebKey, error) {
+	if priv.D == nil {
+		return nil, fmt.Errorf("Invalid EC private key, missing d value")
+	}
+
+	raw, err := fromEcPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	size := curveSize(priv.Curve)
+	if len(priv.D.Bytes()) > size {
+		return nil, fmt.Errorf("Invalid EC private key, d value too large for curve %s", raw.Crv)
+	}
+	raw.D = newFixedSizeBuffer(priv.D.Bytes(), size)
+
+	return raw, nil
+}
+
+func fromEd25519PublicKey(pub ed25519.PublicKey) (*rawJSONWebKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Invalid Ed25519 key, wrong length for x value")
+	}
+
+	return &rawJSONWebKey{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   newBuffer(pub),
+	}, nil
+}
+
+func fromX25519PublicKey(pub *ecdh.PublicKey) (*rawJSONWebKey, error) {
+	if pub == nil || pub.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("Unsupported ECDH curve, only X25519 is supported")
+	}
+
+	return &rawJSONWebKey{
+		Kty: "OKP",
+		Crv: "X25519",
+		X:   newBuffer(pub.Bytes()),
+	}, nil
+}
+
+func fromEd25519PrivateKey(priv ed25519.PrivateKey) (*rawJSONWebKey, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("Invalid Ed25519 private key, wrong length")
+	}
+
+	raw, err := fromEd25519PublicKey(priv.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	raw.D = newBuffer(priv.Seed())
+
+	return raw, nil
+}
+
+func fromX25519PrivateKey(priv *ecdh.PrivateKey) (*rawJSONWebKey, error) {
+	if priv == nil {
+		return nil, fmt.Errorf("Invalid X25519 private key")
+	}
+
+	raw, err := fromX25519PublicKey(priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	raw.D = newBuffer(priv.Bytes())
+
+	return raw, nil
+}
+
+func fromOctKey(k []byte) (*rawJSONWebKey, error) {
+	if len(k) == 0 {
+		return nil, fmt.Errorf("Invalid oct key, missing k value")
+	}
+
+	return &rawJSONWebKey{
+		Kty: "oct",
+		K:   newBuffer(k),
+	}, nil
+}
+
+// keyType returns the JWK kty of the given key.
+func keyType(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return "RSA"
+	case *ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return "EC"
+	case ed25519.PublicKey, ed25519.PrivateKey, *ecdh.PublicKey, *ecdh.PrivateKey:
+		return "OKP"
+	case []byte:
+		return "oct"
+	default:
+		return ""
+	}
+}
+
+// thumbprintKeyID returns the base64url SHA-256 thumbprint of key
+// as defined in RFC 7638, suitable as key ID.
+func thumbprintKeyID(key *JSONWebKey) (string, error) {
+	tp, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return newBuffer(tp).base64(), nil
+}
+
+// publicKey returns the public part of a private key,
+// public and symmetric keys are returned as is.
+func publicKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		if len(k) != ed25519.PrivateKeySize {
+			return nil
+		}
+		return k.Public()
+	case *ecdh.PrivateKey:
+		if k == nil {
+			return nil
+		}
+		return k.PublicKey()
+	default:
+		return key
+	}
+}
+
+// minSymmetricKeySize returns the minimum size in bytes of a symmetric key for alg.
+func minSymmetricKeySize(alg string) int {
+	if size, ok := octKeySizes[alg]; ok {
+		return size
+	}
+	return minOctKeySize
+}
+
+// curveName returns the JWK crv name of the given curve.
+func curveName(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", nil
+	case elliptic.P384():
+		return "P-384", nil
+	case elliptic.P521():
+		return "P-521", nil
+	default:
+		return "", fmt.Errorf("Unsupported EC curve")
+	}
+}
+
+// curveFromName returns the curve of the given JWK crv name.
+func curveFromName(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("Unsupported EC curve '%s'", crv)
+	}
+}
+
+// curveSize returns the size in bytes of a coordinate on the given curve.
+func curveSize(curve elliptic.Curve) int {
+	bits := curve.Params().BitSize
+	return (bits + 7) / 8
+}
+
 func parseCertificateChain(chain []string) ([]*x509.Certificate, error) {
 	certs := make([]*x509.Certificate, len(chain))
 	for i, cert := range chain {
@@ -109,23 +460,364 @@ func parseCertificateChain(chain []string) ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
+// keySnapshot is an immutable key set indexed by key ID.
+type keySnapshot struct {
+	set   JSONWebKeySet
+	index map[string][]int
+}
+
+// newKeySnapshot returns a snapshot of a deep copy of set,
+// certificates are parsed once here and shared by its copies.
+func newKeySnapshot(set *JSONWebKeySet) *keySnapshot {
+	snapshot := &keySnapshot{
+		set:   set.clone(JSONWebKey.clone),
+		index: make(map[string][]int, len(set.Keys)),
+	}
+	for i, key := range snapshot.set.Keys {
+		snapshot.index[key.KeyID] = append(snapshot.index[key.KeyID], i)
+	}
+	return snapshot
+}
+
+// keySet returns a copy of the snapshot key set.
+func (s *keySnapshot) keySet() *JSONWebKeySet {
+	set := s.set.clone(JSONWebKey.cloneShared)
+	return &set
+}
+
+// key returns copies of the keys with the given key ID.
+func (s *keySnapshot) key(kid string) []JSONWebKey {
+	indexes := s.index[kid]
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	keys := make([]JSONWebKey, len(indexes))
+	for i, index := range indexes {
+		keys[i] = s.set.Keys[index].cloneShared()
+	}
+	return keys
+}
+
+// clone returns a copy of the given key set, keys copied by cloneKey.
+func (set *JSONWebKeySet) clone(cloneKey func(JSONWebKey) JSONWebKey) JSONWebKeySet {
+	ret := JSONWebKeySet{
+		Keys:    make([]JSONWebKey, len(set.Keys)),
+		Extra:   cloneMembers(set.Extra),
+		members: set.members,
+	}
+	for i, key := range set.Keys {
+		ret.Keys[i] = cloneKey(key)
+	}
+	return ret
+}
+
+// clone returns a deep copy of the given key,
+// including key material and certificates.
+func (key JSONWebKey) clone() JSONWebKey {
+	ret := key.cloneShared()
+	ret.Certificates = cloneCertificates(key.Certificates)
+	return ret
+}
+
+// cloneShared returns a copy of the given key including key material,
+// sharing the parsed certificates, which are read only.
+func (key JSONWebKey) cloneShared() JSONWebKey {
+	ret := key
+	ret.Key = cloneKeyMaterial(key.Key)
+	ret.Certificates = append([]*x509.Certificate(nil), key.Certificates...)
+	ret.KeyOps = append([]string(nil), key.KeyOps...)
+	ret.CertificateThumbprintSHA1 = append([]byte(nil), key.CertificateThumbprintSHA1...)
+	ret.CertificateThumbprintSHA256 = append([]byte(nil), key.CertificateThumbprintSHA256...)
+	ret.Extra = cloneMembers(key.Extra)
+	if key.CertificatesURL != nil {
+		u := *key.CertificatesURL
+		ret.CertificatesURL = &u
+	}
+	return ret
+}
+
+// cloneKeyMaterial returns a copy of the given raw key,
+// unsupported keys are returned as is.
+func cloneKeyMaterial(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return cloneRsaPublicKey(k)
+	case *rsa.PrivateKey:
+		ret := &rsa.PrivateKey{
+			PublicKey: *cloneRsaPublicKey(&k.PublicKey),
+			D:         cloneBigInt(k.D),
+			Primes:    make([]*big.Int, len(k.Primes)),
+		}
+		for i, p := range k.Primes {
+			ret.Primes[i] = cloneBigInt(p)
+		}
+		if k.Precomputed.Dp != nil {
+			ret.Precompute()
+		}
+		return ret
+	case *ecdsa.PublicKey:
+		return cloneEcPublicKey(k)
+	case *ecdsa.PrivateKey:
+		return &ecdsa.PrivateKey{PublicKey: *cloneEcPublicKey(&k.PublicKey), D: cloneBigInt(k.D)}
+	case ed25519.PublicKey:
+		return append(ed25519.PublicKey(nil), k...)
+	case ed25519.PrivateKey:
+		return append(ed25519.PrivateKey(nil), k...)
+	case *ecdh.PublicKey:
+		if pub, err := k.Curve().NewPublicKey(k.Bytes()); err == nil {
+			return pub
+		}
+	case *ecdh.PrivateKey:
+		if priv, err := k.Curve().NewPrivateKey(k.Bytes()); err == nil {
+			return priv
+		}
+	case []byte:
+		return append([]byte(nil), k...)
+	}
+	return key
+}
+
+func cloneRsaPublicKey(k *rsa.PublicKey) *rsa.PublicKey {
+	return &rsa.PublicKey{N: cloneBigInt(k.N), E: k.E}
+}
+
+func cloneEcPublicKey(k *ecdsa.PublicKey) *ecdsa.PublicKey {
+	return &ecdsa.PublicKey{Curve: k.Curve, X: cloneBigInt(k.X), Y: cloneBigInt(k.Y)}
+}
+
+func cloneBigInt(n *big.Int) *big.Int {
+	if n == nil {
+		return nil
+	}
+	return new(big.Int).Set(n)
+}
+
+// cloneCertificates returns copies of the given certificates,
+// parsed again from their DER encoding.
+func cloneCertificates(certs []*x509.Certificate) []*x509.Certificate {
+	if certs == nil {
+		return nil
+	}
+	ret := make([]*x509.Certificate, len(certs))
+	for i, cert := range certs {
+		if c, err := x509.ParseCertificate(append([]byte(nil), cert.Raw...)); err == nil {
+			ret[i] = c
+		} else {
+			ret[i] = cert
+		}
+	}
+	return ret
+}
+
+// publicKeyOps returns the public counterparts of ops, without duplicates,
+// operations needing the private key only are dropped.
+func publicKeyOps(ops []string) []string {
+	var ret []string
+	for _, op := range ops {
+		if pub, ok := publicOps[op]; ok && !contains(ret, pub) {
+			ret = append(ret, pub)
+		}
+	}
+	return ret
+}
+
+func cloneMembers(members map[string]json.RawMessage) map[string]json.RawMessage {
+	if members == nil {
+		return nil
+	}
+	ret := make(map[string]json.RawMessage, len(members))
+	for name, value := range members {
+		ret[name] = append(json.RawMessage(nil), value...)
+	}
+	return ret
+}
+
 type watchdog struct {
+	mutex  sync.Mutex
 	period time.Duration
-	ticker *time.Ticker
+	timer  *time.Timer
+	next   time.Time
 }
 
 func createWatchdog(period time.Duration) *watchdog {
-	return &watchdog{period, time.NewTicker(period)}
+	timer := time.NewTimer(period)
+	timer.Stop()
+	return &watchdog{period: period, timer: timer}
+}
+
+// reset schedules the next tick after d.
+func (w *watchdog) reset(d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer == nil {
+		return
+	}
+	if !w.timer.Stop() {
+		select {
+		case <-w.timer.C:
+		default:
+		}
+	}
+	w.timer.Reset(d)
+	w.next = time.Now().Add(d)
 }
 
-func (w *watchdog) resetTicker() {
-	w.ticker.Stop()
-	w.ticker = time.NewTicker(w.period)
+func (w *watchdog) nextTick() time.Time {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.next
 }
 
 func (w *watchdog) stop() {
-	w.ticker.Stop()
-	w.ticker = nil
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.timer.Stop()
+	w.timer = nil
+	w.next = time.Time{}
+}
+
+// jsonMembers returns the JSON member names of the given struct.
+func jsonMembers(v interface{}) map[string]bool {
+	members := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			members[name] = true
+		}
+	}
+	return members
+}
+
+// marshalJSON is json.Marshal without HTML escaping,
+// so that member values are emitted as they were parsed.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// objectMembers returns the member names of a JSON object in document order.
+func objectMembers(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("Invalid JSON object")
+	}
+
+	var names []string
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		names = append(names, tok.(string))
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// splitMembers returns the members of a JSON object not in known,
+// along with the order of all members.
+func splitMembers(data []byte, known map[string]bool) (map[string]json.RawMessage, []string, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, nil, err
+	}
+
+	var extra map[string]json.RawMessage
+	for name, value := range all {
+		if known[name] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[name] = value
+	}
+
+	members, err := objectMembers(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return extra, members, nil
+}
+
+// mergeMembers adds extra members to the JSON object in data,
+// emitting members in the given order first, then the members of data,
+// then the remaining extra members sorted by name.
+func mergeMembers(data []byte, extra map[string]json.RawMessage, order []string, known map[string]bool) ([]byte, error) {
+	if len(extra) == 0 && len(order) == 0 {
+		return data, nil
+	}
+
+	names, err := objectMembers(data)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	extraNames := make([]string, 0, len(extra))
+	for name, value := range extra {
+		if known[name] {
+			return nil, fmt.Errorf("Extra member '%s' conflicts with a registered member", name)
+		}
+		var buf bytes.Buffer
+		if err = json.Compact(&buf, value); err != nil {
+			return nil, fmt.Errorf("Invalid value for extra member '%s': %s", name, err)
+		}
+		values[name] = buf.Bytes()
+		extraNames = append(extraNames, name)
+	}
+	sort.Strings(extraNames)
+
+	var buf bytes.Buffer
+	emitted := make(map[string]bool, len(values))
+	emit := func(name string) error {
+		value, ok := values[name]
+		if !ok || emitted[name] {
+			return nil
+		}
+		if len(emitted) > 0 {
+			buf.WriteByte(',')
+		}
+		emitted[name] = true
+
+		key, err := marshalJSON(name)
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+		return nil
+	}
+
+	buf.WriteByte('{')
+	for _, group := range [][]string{order, names, extraNames} {
+		for _, name := range group {
+			if err = emit(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
 }
 
 // Decode base64-encoded string into byte array for testing.
@@ -0,0 +1,223 @@
+package jwk
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+type (
+	// KeyPolicy is the minimum key strength required by Validate.
+	KeyPolicy struct {
+		// MinRSABits is the minimum RSA modulus size in bits.
+		MinRSABits int
+		// MinSymmetricBytes is the minimum symmetric key size in bytes,
+		// on top of the minimum required by the key algorithm.
+		MinSymmetricBytes int
+		// AllowedCurves lists accepted EC and OKP curves by JWK crv name,
+		// all supported curves are accepted if empty.
+		AllowedCurves []string
+	}
+
+	// ValidationError explains why a key is invalid.
+	ValidationError struct {
+		KeyID string
+		// Err is one of the Err values describing the failure.
+		Err    error
+		Reason string
+	}
+)
+
+var (
+	// DefaultKeyPolicy is the default Key Policy.
+	DefaultKeyPolicy = KeyPolicy{
+		MinRSABits: 2048,
+	}
+
+	// ErrMissingKey is returned for a JWK without key.
+	ErrMissingKey = errors.New("Missing key")
+	// ErrUnknownKeyType is returned for unsupported keys.
+	ErrUnknownKeyType = errors.New("Unknown key type")
+	// ErrMalformedKey is returned for keys missing values or with inconsistent values.
+	ErrMalformedKey = errors.New("Malformed key")
+	// ErrWeakKey is returned for keys not meeting the Key Policy.
+	ErrWeakKey = errors.New("Weak key")
+	// ErrInvalidExponent is returned for RSA exponents out of range or even.
+	ErrInvalidExponent = errors.New("Invalid RSA exponent")
+	// ErrInvalidPoint is returned for EC points not on their curve.
+	ErrInvalidPoint = errors.New("Point not on curve")
+	// ErrAlgorithmMismatch is returned when alg does not apply to the key.
+	ErrAlgorithmMismatch = errors.New("Algorithm does not match key")
+	// ErrUseConflict is returned when use and key_ops contradict.
+	ErrUseConflict = errors.New("Use and key_ops conflict")
+	// ErrInvalidParameter is returned for invalid x5t, x5t#S256 or key_ops values.
+	ErrInvalidParameter = errors.New("Invalid key parameter")
+)
+
+// algCurves maps registered algorithms to the curve they require.
+var algCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+	"EdDSA": "Ed25519",
+}
+
+// Validate checks the given key against policy, it returns
+// a *ValidationError explaining what is wrong with the key.
+func (key *JSONWebKey) Validate(policy KeyPolicy) error {
+	if key.Key == nil {
+		return key.invalid(ErrMissingKey, "no key material")
+	}
+
+	if err := key.checkConsistency(); err != nil {
+		return err
+	}
+
+	if err := key.validateKey(policy); err != nil {
+		return err
+	}
+
+	kty := key.KeyType()
+	if ktys, ok := algKeyTypes[key.Algorithm]; ok && !contains(ktys, kty) {
+		return key.invalid(ErrAlgorithmMismatch, "alg '%s' does not apply to kty '%s'", key.Algorithm, kty)
+	}
+	if crv, ok := algCurves[key.Algorithm]; ok && crv != keyCurve(key.Key) {
+		return key.invalid(ErrAlgorithmMismatch, "alg '%s' requires curve %s", key.Algorithm, crv)
+	}
+
+	if len(policy.AllowedCurves) > 0 {
+		if crv := keyCurve(key.Key); crv != "" && !contains(policy.AllowedCurves, crv) {
+			return key.invalid(ErrWeakKey, "curve %s is not allowed", crv)
+		}
+	}
+
+	return nil
+}
+
+func (key *JSONWebKey) validateKey(policy KeyPolicy) error {
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		return key.validateRSA(k, policy)
+	case *rsa.PrivateKey:
+		if err := key.validateRSA(&k.PublicKey, policy); err != nil {
+			return err
+		}
+		if k.D == nil || len(k.Primes) != 2 {
+			return key.invalid(ErrMalformedKey, "RSA private key requires d and two primes")
+		}
+		if err := k.Validate(); err != nil {
+			return key.invalid(ErrMalformedKey, "%s", err)
+		}
+	case *ecdsa.PublicKey:
+		return key.validateEC(k)
+	case *ecdsa.PrivateKey:
+		if err := key.validateEC(&k.PublicKey); err != nil {
+			return err
+		}
+		if k.D == nil || k.D.Sign() <= 0 || k.D.Cmp(k.Curve.Params().N) >= 0 {
+			return key.invalid(ErrMalformedKey, "EC private key d value out of range")
+		}
+		if x, y := k.Curve.ScalarBaseMult(k.D.Bytes()); x.Cmp(k.X) != 0 || y.Cmp(k.Y) != 0 {
+			return key.invalid(ErrMalformedKey, "EC private key d value does not match x/y values")
+		}
+	case ed25519.PublicKey:
+		if len(k) != ed25519.PublicKeySize {
+			return key.invalid(ErrMalformedKey, "Ed25519 key wrong length")
+		}
+	case ed25519.PrivateKey:
+		if len(k) != ed25519.PrivateKeySize {
+			return key.invalid(ErrMalformedKey, "Ed25519 private key wrong length")
+		}
+	case *ecdh.PublicKey:
+		if k == nil || k.Curve() != ecdh.X25519() {
+			return key.invalid(ErrUnknownKeyType, "only X25519 ECDH keys are supported")
+		}
+	case *ecdh.PrivateKey:
+		if k == nil || k.Curve() != ecdh.X25519() {
+			return key.invalid(ErrUnknownKeyType, "only X25519 ECDH keys are supported")
+		}
+	case []byte:
+		size := minSymmetricKeySize(key.Algorithm)
+		if policy.MinSymmetricBytes > size {
+			size = policy.MinSymmetricBytes
+		}
+		if len(k) < size {
+			return key.invalid(ErrWeakKey, "symmetric key is %d bytes, minimum is %d", len(k), size)
+		}
+	default:
+		return key.invalid(ErrUnknownKeyType, "'%s'", reflect.TypeOf(k))
+	}
+
+	return nil
+}
+
+func (key *JSONWebKey) validateRSA(k *rsa.PublicKey, policy KeyPolicy) error {
+	if k.N == nil || k.N.Sign() <= 0 {
+		return key.invalid(ErrMalformedKey, "RSA key missing n value")
+	}
+	if k.E < 3 || k.E > math.MaxInt32 || k.E%2 == 0 {
+		return key.invalid(ErrInvalidExponent, "e value %d is out of range or even", k.E)
+	}
+	if bits := k.N.BitLen(); bits < policy.MinRSABits {
+		return key.invalid(ErrWeakKey, "RSA modulus is %d bits, minimum is %d", bits, policy.MinRSABits)
+	}
+	return nil
+}
+
+func (key *JSONWebKey) validateEC(k *ecdsa.PublicKey) error {
+	if k.Curve == nil || k.X == nil || k.Y == nil {
+		return key.invalid(ErrMalformedKey, "EC key missing crv/x/y values")
+	}
+	if _, err := curveName(k.Curve); err != nil {
+		return key.invalid(ErrUnknownKeyType, "%s", err)
+	}
+	if !k.Curve.IsOnCurve(k.X, k.Y) {
+		return key.invalid(ErrInvalidPoint, "point (x, y) is not on curve %s", k.Curve.Params().Name)
+	}
+	return nil
+}
+
+// invalid returns a *ValidationError for the given key.
+func (key *JSONWebKey) invalid(err error, format string, args ...interface{}) error {
+	return &ValidationError{
+		KeyID:  key.KeyID,
+		Err:    err,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error returns the invalid key ID, the error and the reason.
+func (e *ValidationError) Error() string {
+	if e.KeyID == "" {
+		return fmt.Sprintf("Invalid key: %s, %s", e.Err, e.Reason)
+	}
+	return fmt.Sprintf("Invalid key '%s': %s, %s", e.KeyID, e.Err, e.Reason)
+}
+
+// Unwrap returns the Err value describing the failure.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// keyCurve returns the JWK crv name of EC and OKP keys.
+func keyCurve(key interface{}) string {
+	switch k := publicKey(key).(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve == nil {
+			return ""
+		}
+		crv, _ := curveName(k.Curve)
+		return crv
+	case ed25519.PublicKey:
+		return "Ed25519"
+	case *ecdh.PublicKey:
+		return "X25519"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,75 @@
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	weakRSAKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsaPub := rsaTestKey.PublicKey
+
+	evenExponent := rsaPub
+	evenExponent.E = 65536
+	hugeExponent := rsaPub
+	hugeExponent.E = 1 << 40
+
+	offCurve := ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)}
+
+	cases := []struct {
+		key      JSONWebKey
+		policy   KeyPolicy
+		expected error
+	}{
+		{JSONWebKey{Key: &rsaPub}, DefaultKeyPolicy, nil},
+		{JSONWebKey{Key: &weakRSAKey.PublicKey}, KeyPolicy{}, nil},
+		{JSONWebKey{Key: &weakRSAKey.PublicKey}, DefaultKeyPolicy, ErrWeakKey},
+		{JSONWebKey{Key: &evenExponent}, KeyPolicy{}, ErrInvalidExponent},
+		{JSONWebKey{Key: &hugeExponent}, KeyPolicy{}, ErrInvalidExponent},
+		{JSONWebKey{Key: &offCurve}, KeyPolicy{}, ErrInvalidPoint},
+		{JSONWebKey{Key: &ecTestKey256.PublicKey, Algorithm: "ES256"}, KeyPolicy{}, nil},
+		{JSONWebKey{Key: &ecTestKey256.PublicKey, Algorithm: "ES384"}, KeyPolicy{}, ErrAlgorithmMismatch},
+		{JSONWebKey{Key: &ecTestKey256.PublicKey, Algorithm: "RS256"}, KeyPolicy{}, ErrAlgorithmMismatch},
+		{JSONWebKey{Key: x25519TestKey.PublicKey(), Algorithm: "EdDSA"}, KeyPolicy{}, ErrAlgorithmMismatch},
+		{JSONWebKey{Key: &ecTestKey256.PublicKey}, KeyPolicy{AllowedCurves: []string{"P-384"}}, ErrWeakKey},
+		{JSONWebKey{Key: ed25519TestPublicKey}, KeyPolicy{AllowedCurves: []string{"Ed25519"}}, nil},
+		{JSONWebKey{Key: &rsaPub, Use: "sig", KeyOps: []string{"encrypt"}}, KeyPolicy{}, ErrUseConflict},
+		{JSONWebKey{Key: &rsaPub, KeyOps: []string{"sign", "sign"}}, KeyPolicy{}, ErrInvalidParameter},
+		{JSONWebKey{Key: make([]byte, 32), Algorithm: "HS512"}, KeyPolicy{}, ErrWeakKey},
+		{JSONWebKey{Key: make([]byte, 32)}, KeyPolicy{MinSymmetricBytes: 64}, ErrWeakKey},
+		{JSONWebKey{Key: make([]byte, 32), Algorithm: "HS256"}, KeyPolicy{}, nil},
+		{JSONWebKey{Key: make([]byte, 32), Algorithm: "RS256"}, KeyPolicy{}, ErrAlgorithmMismatch},
+		{JSONWebKey{}, KeyPolicy{}, ErrMissingKey},
+		{JSONWebKey{Key: "key"}, KeyPolicy{}, ErrUnknownKeyType},
+		{JSONWebKey{Key: &rsa.PrivateKey{PublicKey: rsaPub}}, KeyPolicy{}, ErrMalformedKey},
+	}
+
+	for i, tc := range cases {
+		tc.key.KeyID = fmt.Sprintf("case-%d", i)
+		err := tc.key.Validate(tc.policy)
+		if tc.expected == nil {
+			assert(t, err == nil, fmt.Sprintf("case %d should be valid, got %s", i, err))
+			continue
+		}
+
+		assert(t, errors.Is(err, tc.expected), fmt.Sprintf("case %d expected %s, got %v", i, tc.expected, err))
+		var verr *ValidationError
+		assert(t, errors.As(err, &verr) && verr.KeyID == tc.key.KeyID && verr.Reason != "",
+			fmt.Sprintf("case %d should return a ValidationError with reason", i))
+	}
+}
+
+func TestUnmarshalExponentOverflow(t *testing.T) {
+	// e of 2^64 + 3 would overflow to 3
+	key := `{"kty":"RSA","n":"` + testCertificateModulus + `","e":"AQAAAAAAAAAD"}`
+
+	var jwk JSONWebKey
+	err := jwk.UnmarshalJSON([]byte(key))
+	assert(t, err != nil, "managed to parse key with overflowing exponent")
+}